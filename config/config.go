@@ -1,10 +1,12 @@
 package config
 
 import (
+	"crypto"
 	"fmt"
 	"log"
 	"math"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -56,6 +58,7 @@ func LoadConfig(path string) IConfig {
 				}
 				return b
 			}(),
+			clientUrl: strings.TrimSuffix(envMap["APP_CLIENT_URL"], "/"),
 		},
 		db: &db{
 			host: envMap["DB_HOST"],
@@ -96,7 +99,292 @@ func LoadConfig(path string) IConfig {
 				}
 				return r
 			}(),
+			challengeExpiresAt: func() int {
+				if envMap["JWT_CHALLENGE_EXPIRES"] == "" {
+					return 300
+				}
+				x, err := strconv.Atoi(envMap["JWT_CHALLENGE_EXPIRES"])
+				if err != nil {
+					log.Fatalf("load challenge expires failed: %v", err)
+				}
+				return x
+			}(),
+			impersonationExpiresAt: func() int {
+				if envMap["JWT_IMPERSONATION_EXPIRES"] == "" {
+					return 900
+				}
+				x, err := strconv.Atoi(envMap["JWT_IMPERSONATION_EXPIRES"])
+				if err != nil {
+					log.Fatalf("load impersonation expires failed: %v", err)
+				}
+				return x
+			}(),
+			authMode: func() string {
+				switch envMap["JWT_AUTH_MODE"] {
+				case "":
+					return "stateful"
+				case "stateful", "stateless", "hybrid":
+					return envMap["JWT_AUTH_MODE"]
+				default:
+					log.Fatalf("load jwt auth mode failed: unknown mode %s", envMap["JWT_AUTH_MODE"])
+				}
+				return ""
+			}(),
+			tokenCacheSize: func() int {
+				if envMap["JWT_TOKEN_CACHE_SIZE"] == "" {
+					return 10000
+				}
+				x, err := strconv.Atoi(envMap["JWT_TOKEN_CACHE_SIZE"])
+				if err != nil {
+					log.Fatalf("load token cache size failed: %v", err)
+				}
+				return x
+			}(),
+			tokenCacheTtl: func() int {
+				if envMap["JWT_TOKEN_CACHE_TTL"] == "" {
+					return 60
+				}
+				x, err := strconv.Atoi(envMap["JWT_TOKEN_CACHE_TTL"])
+				if err != nil {
+					log.Fatalf("load token cache ttl failed: %v", err)
+				}
+				return x
+			}(),
+			jwtKeys: loadJwtKeys(envMap),
 		},
+		mail: &mail{
+			driver: func() string {
+				if envMap["MAIL_DRIVER"] == "" {
+					return "stdout"
+				}
+				return envMap["MAIL_DRIVER"]
+			}(),
+			host: envMap["MAIL_HOST"],
+			port: func() int {
+				if envMap["MAIL_PORT"] == "" {
+					return 587
+				}
+				p, err := strconv.Atoi(envMap["MAIL_PORT"])
+				if err != nil {
+					log.Fatalf("load mail port failed: %v", err)
+				}
+				return p
+			}(),
+			username: envMap["MAIL_USERNAME"],
+			password: envMap["MAIL_PASSWORD"],
+			from:     envMap["MAIL_FROM"],
+			filePath: envMap["MAIL_FILE_PATH"],
+		},
+		users: &users{
+			passwordResetExpiresAt: func() int {
+				if envMap["USERS_PASSWORD_RESET_EXPIRES"] == "" {
+					return 3600
+				}
+				x, err := strconv.Atoi(envMap["USERS_PASSWORD_RESET_EXPIRES"])
+				if err != nil {
+					log.Fatalf("load password reset expires failed: %v", err)
+				}
+				return x
+			}(),
+			verificationExpiresAt: func() int {
+				if envMap["USERS_VERIFICATION_EXPIRES"] == "" {
+					return 86400
+				}
+				x, err := strconv.Atoi(envMap["USERS_VERIFICATION_EXPIRES"])
+				if err != nil {
+					log.Fatalf("load verification expires failed: %v", err)
+				}
+				return x
+			}(),
+			unverifiedPolicy: func() string {
+				switch envMap["USERS_UNVERIFIED_POLICY"] {
+				case "":
+					return "content"
+				case "none", "content", "write":
+					return envMap["USERS_UNVERIFIED_POLICY"]
+				default:
+					log.Fatalf("load unverified policy failed: unknown policy %s", envMap["USERS_UNVERIFIED_POLICY"])
+				}
+				return ""
+			}(),
+			loginThrottleStore: func() string {
+				switch envMap["LOGIN_THROTTLE_STORE"] {
+				case "":
+					return "memory"
+				case "memory", "postgres":
+					return envMap["LOGIN_THROTTLE_STORE"]
+				default:
+					log.Fatalf("load login throttle store failed: unknown store %s", envMap["LOGIN_THROTTLE_STORE"])
+				}
+				return ""
+			}(),
+			loginMaxAttempts: func() int {
+				if envMap["LOGIN_MAX_ATTEMPTS"] == "" {
+					return 5
+				}
+				x, err := strconv.Atoi(envMap["LOGIN_MAX_ATTEMPTS"])
+				if err != nil {
+					log.Fatalf("load login max attempts failed: %v", err)
+				}
+				return x
+			}(),
+			loginIpMaxAttempts: func() int {
+				if envMap["LOGIN_IP_MAX_ATTEMPTS"] == "" {
+					return 50
+				}
+				x, err := strconv.Atoi(envMap["LOGIN_IP_MAX_ATTEMPTS"])
+				if err != nil {
+					log.Fatalf("load login ip max attempts failed: %v", err)
+				}
+				return x
+			}(),
+			loginBackoffAfter: func() int {
+				if envMap["LOGIN_BACKOFF_AFTER"] == "" {
+					return 3
+				}
+				x, err := strconv.Atoi(envMap["LOGIN_BACKOFF_AFTER"])
+				if err != nil {
+					log.Fatalf("load login backoff after failed: %v", err)
+				}
+				return x
+			}(),
+			loginBackoffBase: func() int {
+				if envMap["LOGIN_BACKOFF_BASE"] == "" {
+					return 1
+				}
+				x, err := strconv.Atoi(envMap["LOGIN_BACKOFF_BASE"])
+				if err != nil {
+					log.Fatalf("load login backoff base failed: %v", err)
+				}
+				return x
+			}(),
+			loginLockoutDuration: func() int {
+				if envMap["LOGIN_LOCKOUT_DURATION"] == "" {
+					return 900
+				}
+				x, err := strconv.Atoi(envMap["LOGIN_LOCKOUT_DURATION"])
+				if err != nil {
+					log.Fatalf("load login lockout duration failed: %v", err)
+				}
+				return x
+			}(),
+			loginAttemptWindow: func() int {
+				if envMap["LOGIN_ATTEMPT_WINDOW"] == "" {
+					return 900
+				}
+				x, err := strconv.Atoi(envMap["LOGIN_ATTEMPT_WINDOW"])
+				if err != nil {
+					log.Fatalf("load login attempt window failed: %v", err)
+				}
+				return x
+			}(),
+			magicLinkExpiresAt: func() int {
+				if envMap["USERS_MAGIC_LINK_EXPIRES"] == "" {
+					return 900
+				}
+				x, err := strconv.Atoi(envMap["USERS_MAGIC_LINK_EXPIRES"])
+				if err != nil {
+					log.Fatalf("load magic link expires failed: %v", err)
+				}
+				return x
+			}(),
+			magicLinkMaxRequests: func() int {
+				if envMap["USERS_MAGIC_LINK_MAX_REQUESTS"] == "" {
+					return 3
+				}
+				x, err := strconv.Atoi(envMap["USERS_MAGIC_LINK_MAX_REQUESTS"])
+				if err != nil {
+					log.Fatalf("load magic link max requests failed: %v", err)
+				}
+				return x
+			}(),
+			magicLinkWindow: func() int {
+				if envMap["USERS_MAGIC_LINK_WINDOW"] == "" {
+					return 900
+				}
+				x, err := strconv.Atoi(envMap["USERS_MAGIC_LINK_WINDOW"])
+				if err != nil {
+					log.Fatalf("load magic link window failed: %v", err)
+				}
+				return x
+			}(),
+			registrationMode: func() string {
+				switch envMap["USERS_REGISTRATION_MODE"] {
+				case "":
+					return "open"
+				case "open", "invite", "closed":
+					return envMap["USERS_REGISTRATION_MODE"]
+				default:
+					log.Fatalf("load registration mode failed: unknown mode %s", envMap["USERS_REGISTRATION_MODE"])
+				}
+				return ""
+			}(),
+			invitesByUsers: envMap["USERS_INVITES_BY_USERS"] == "true",
+			deletionGracePeriod: func() int {
+				if envMap["USERS_DELETION_GRACE_PERIOD"] == "" {
+					return 2592000
+				}
+				x, err := strconv.Atoi(envMap["USERS_DELETION_GRACE_PERIOD"])
+				if err != nil {
+					log.Fatalf("load deletion grace period failed: %v", err)
+				}
+				return x
+			}(),
+			passwordMinLength: func() int {
+				if envMap["PASSWORD_MIN_LENGTH"] == "" {
+					return 8
+				}
+				x, err := strconv.Atoi(envMap["PASSWORD_MIN_LENGTH"])
+				if err != nil {
+					log.Fatalf("load password min length failed: %v", err)
+				}
+				return x
+			}(),
+			passwordClasses: func() []string {
+				classes := make([]string, 0)
+				for _, class := range strings.Split(envMap["PASSWORD_REQUIRE_CLASSES"], ",") {
+					class = strings.ToLower(strings.TrimSpace(class))
+					switch class {
+					case "":
+					case "upper", "lower", "digit", "symbol":
+						classes = append(classes, class)
+					default:
+						log.Fatalf("load password classes failed: unknown class %s", class)
+					}
+				}
+				return classes
+			}(),
+			passwordBreachedFile: envMap["PASSWORD_BREACHED_FILE"],
+			notifierDriver: func() string {
+				switch envMap["NOTIFIER_DRIVER"] {
+				case "":
+					return "mail"
+				case "mail", "none":
+					return envMap["NOTIFIER_DRIVER"]
+				case "webhook":
+					if envMap["NOTIFIER_WEBHOOK_URL"] == "" {
+						log.Fatalf("load notifier failed: NOTIFIER_WEBHOOK_URL is required")
+					}
+					return envMap["NOTIFIER_DRIVER"]
+				default:
+					log.Fatalf("load notifier failed: unknown driver %s", envMap["NOTIFIER_DRIVER"])
+				}
+				return ""
+			}(),
+			notifierWebhookUrl: envMap["NOTIFIER_WEBHOOK_URL"],
+			deletionContent: func() string {
+				switch envMap["USERS_DELETION_CONTENT"] {
+				case "":
+					return "anonymize"
+				case "anonymize", "delete":
+					return envMap["USERS_DELETION_CONTENT"]
+				default:
+					log.Fatalf("load deletion content failed: unknown mode %s", envMap["USERS_DELETION_CONTENT"])
+				}
+				return ""
+			}(),
+		},
+		oidc: loadOidc(envMap, strings.TrimSuffix(envMap["APP_CLIENT_URL"], "/")),
 	}
 }
 
@@ -104,12 +392,18 @@ type IConfig interface {
 	App() IAppConfig
 	Db() IDbConfig
 	Jwt() IJwtConfig
+	Mail() IMailConfig
+	Users() IUsersConfig
+	Oidc() IOidcConfig
 }
 
 type config struct {
-	app *app
-	db  *db
-	jwt *jwt
+	app   *app
+	db    *db
+	jwt   *jwt
+	mail  *mail
+	users *users
+	oidc  *oidc
 }
 
 type IAppConfig interface {
@@ -120,6 +414,7 @@ type IAppConfig interface {
 	WriteTimeout() time.Duration
 	BodyLimit() int
 	FileLimit() int
+	ClientUrl() string
 }
 type app struct {
 	host         string
@@ -130,6 +425,7 @@ type app struct {
 	writeTimeout time.Duration
 	bodyLimit    int //Bytes
 	fileLimit    int //Bytes
+	clientUrl    string
 }
 
 func (c *config) App() IAppConfig {
@@ -143,6 +439,7 @@ func (a *app) ReadTimeout() time.Duration  { return a.readTimeout }
 func (a *app) WriteTimeout() time.Duration { return a.writeTimeout }
 func (a *app) BodyLimit() int              { return a.bodyLimit }
 func (a *app) FileLimit() int              { return a.fileLimit }
+func (a *app) ClientUrl() string           { return a.clientUrl }
 
 type IDbConfig interface {
 	Url() string
@@ -182,14 +479,31 @@ type IJwtConfig interface {
 	APiKey() []byte
 	AccessExpiresAt() int
 	RefreshExpiresAt() int
+	ChallengeExpiresAt() int
+	ImpersonationExpiresAt() int
+	AuthMode() string
+	TokenCacheSize() int
+	TokenCacheTtl() time.Duration
 	SetJwtAcessExpires(t int)
 	SetJwtRefreshExpires(t int)
+	SigningMethod() string
+	SigningKey() crypto.Signer
+	KeyId() string
+	VerifyKey(kid string) crypto.PublicKey
+	VerifyKeys() map[string]crypto.PublicKey
 }
 type jwt struct {
-	secretKey        string
-	apiKey           string
-	accessExpiresAt  int //sec
-	refreshExpiresAt int //sec
+	secretKey          string
+	apiKey             string
+	accessExpiresAt    int //sec
+	refreshExpiresAt   int //sec
+	challengeExpiresAt int //sec
+	tokenCacheSize     int //0 disables the cache
+	tokenCacheTtl      int //sec
+	authMode           string
+
+	impersonationExpiresAt int //sec
+	*jwtKeys
 }
 
 func (c *config) Jwt() IJwtConfig {
@@ -201,3 +515,166 @@ func (j *jwt) AccessExpiresAt() int       { return j.accessExpiresAt }
 func (j *jwt) RefreshExpiresAt() int      { return j.refreshExpiresAt }
 func (j *jwt) SetJwtAcessExpires(t int)   { j.accessExpiresAt = t }
 func (j *jwt) SetJwtRefreshExpires(t int) { j.refreshExpiresAt = t }
+func (j *jwt) ChallengeExpiresAt() int    { return j.challengeExpiresAt }
+func (j *jwt) AuthMode() string           { return j.authMode }
+func (j *jwt) TokenCacheSize() int        { return j.tokenCacheSize }
+func (j *jwt) SigningMethod() string      { return j.signingMethod }
+func (j *jwt) SigningKey() crypto.Signer  { return j.signingKey }
+func (j *jwt) KeyId() string              { return j.keyId }
+func (j *jwt) ImpersonationExpiresAt() int {
+	return j.impersonationExpiresAt
+}
+func (j *jwt) TokenCacheTtl() time.Duration {
+	return time.Duration(j.tokenCacheTtl) * time.Second
+}
+func (j *jwt) VerifyKey(kid string) crypto.PublicKey {
+	return j.verifyKeys[kid]
+}
+func (j *jwt) VerifyKeys() map[string]crypto.PublicKey {
+	return j.verifyKeys
+}
+
+type IMailConfig interface {
+	Driver() string
+	Host() string
+	Port() int
+	Username() string
+	Password() string
+	From() string
+	FilePath() string
+}
+type mail struct {
+	driver   string //smtp, file or stdout
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+	filePath string
+}
+
+func (c *config) Mail() IMailConfig {
+	return c.mail
+}
+func (m *mail) Driver() string   { return m.driver }
+func (m *mail) Host() string     { return m.host }
+func (m *mail) Port() int        { return m.port }
+func (m *mail) Username() string { return m.username }
+func (m *mail) Password() string { return m.password }
+func (m *mail) From() string     { return m.from }
+func (m *mail) FilePath() string { return m.filePath }
+
+type IUsersConfig interface {
+	PasswordResetExpiresAt() int
+	VerificationExpiresAt() int
+	UnverifiedPolicy() string
+	LoginThrottleStore() string
+	LoginMaxAttempts() int
+	LoginIpMaxAttempts() int
+	LoginBackoffAfter() int
+	LoginBackoffBase() time.Duration
+	LoginLockoutDuration() time.Duration
+	LoginAttemptWindow() time.Duration
+	MagicLinkExpiresAt() int
+	MagicLinkMaxRequests() int
+	MagicLinkWindow() time.Duration
+	RegistrationMode() string
+	InvitesByUsers() bool
+	DeletionGracePeriod() int
+	DeletionContent() string
+	PasswordMinLength() int
+	PasswordClasses() []string
+	PasswordBreachedFile() string
+	NotifierDriver() string
+	NotifierWebhookUrl() string
+}
+type users struct {
+	passwordResetExpiresAt int    //sec
+	verificationExpiresAt  int    //sec
+	unverifiedPolicy       string //none, content or write
+	loginThrottleStore     string //memory or postgres
+	loginMaxAttempts       int
+	loginIpMaxAttempts     int
+	loginBackoffAfter      int
+	loginBackoffBase       int //sec
+	loginLockoutDuration   int //sec
+	loginAttemptWindow     int //sec
+	magicLinkExpiresAt     int //sec
+	magicLinkMaxRequests   int
+	magicLinkWindow        int //sec
+	invitesByUsers         bool
+	registrationMode       string //open, invite or closed
+	deletionGracePeriod    int    //sec
+	deletionContent        string //anonymize or delete
+	passwordMinLength      int
+	passwordClasses        []string
+	passwordBreachedFile   string
+	notifierWebhookUrl     string
+	notifierDriver         string //mail, webhook or none
+}
+
+func (c *config) Users() IUsersConfig {
+	return c.users
+}
+func (u *users) PasswordResetExpiresAt() int { return u.passwordResetExpiresAt }
+func (u *users) VerificationExpiresAt() int  { return u.verificationExpiresAt }
+func (u *users) UnverifiedPolicy() string    { return u.unverifiedPolicy }
+func (u *users) LoginThrottleStore() string  { return u.loginThrottleStore }
+func (u *users) LoginMaxAttempts() int       { return u.loginMaxAttempts }
+func (u *users) LoginIpMaxAttempts() int     { return u.loginIpMaxAttempts }
+func (u *users) LoginBackoffAfter() int      { return u.loginBackoffAfter }
+func (u *users) MagicLinkExpiresAt() int     { return u.magicLinkExpiresAt }
+func (u *users) MagicLinkMaxRequests() int   { return u.magicLinkMaxRequests }
+func (u *users) RegistrationMode() string    { return u.registrationMode }
+func (u *users) InvitesByUsers() bool        { return u.invitesByUsers }
+func (u *users) DeletionGracePeriod() int    { return u.deletionGracePeriod }
+func (u *users) DeletionContent() string     { return u.deletionContent }
+func (u *users) PasswordMinLength() int      { return u.passwordMinLength }
+func (u *users) PasswordClasses() []string   { return u.passwordClasses }
+func (u *users) PasswordBreachedFile() string {
+	return u.passwordBreachedFile
+}
+func (u *users) NotifierDriver() string { return u.notifierDriver }
+func (u *users) NotifierWebhookUrl() string {
+	return u.notifierWebhookUrl
+}
+func (u *users) LoginBackoffBase() time.Duration {
+	return time.Duration(u.loginBackoffBase) * time.Second
+}
+func (u *users) LoginLockoutDuration() time.Duration {
+	return time.Duration(u.loginLockoutDuration) * time.Second
+}
+func (u *users) LoginAttemptWindow() time.Duration {
+	return time.Duration(u.loginAttemptWindow) * time.Second
+}
+func (u *users) MagicLinkWindow() time.Duration {
+	return time.Duration(u.magicLinkWindow) * time.Second
+}
+
+type IOidcConfig interface {
+	Providers() []IOidcProviderConfig
+	Provider(name string) (IOidcProviderConfig, bool)
+	StateExpiresAt() int
+}
+
+type oidc struct {
+	names          []string
+	providers      map[string]*oidcProvider
+	stateExpiresAt int //sec
+}
+
+func (c *config) Oidc() IOidcConfig {
+	return c.oidc
+}
+func (o *oidc) StateExpiresAt() int { return o.stateExpiresAt }
+func (o *oidc) Providers() []IOidcProviderConfig {
+	providers := make([]IOidcProviderConfig, 0, len(o.names))
+	for _, name := range o.names {
+		providers = append(providers, o.providers[name])
+	}
+	return providers
+}
+func (o *oidc) Provider(name string) (IOidcProviderConfig, bool) {
+	p, ok := o.providers[name]
+	return p, ok
+}
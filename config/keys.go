@@ -0,0 +1,149 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+type jwtKeys struct {
+	signingMethod string //HS256, RS256 or EdDSA
+	signingKey    crypto.Signer
+	keyId         string
+	verifyKeys    map[string]crypto.PublicKey
+}
+
+// loadJwtKeys reads the signing key and the extra verification keys.
+// JWT_VERIFY_KEY_FILES is a comma separated list of "kid:path" public keys,
+// old keys stay there while tokens signed with them are still alive.
+func loadJwtKeys(envMap map[string]string) *jwtKeys {
+	keys := &jwtKeys{
+		signingMethod: envMap["JWT_SIGNING_METHOD"],
+		keyId:         envMap["JWT_KEY_ID"],
+		verifyKeys:    make(map[string]crypto.PublicKey),
+	}
+
+	switch keys.signingMethod {
+	case "":
+		keys.signingMethod = "HS256"
+	case "HS256":
+	case "RS256", "EdDSA":
+		signer, err := loadPrivateKey(envMap["JWT_SIGNING_KEY_FILE"])
+		if err != nil {
+			log.Fatalf("load jwt signing key failed: %v", err)
+		}
+		if err := checkKeyType(keys.signingMethod, signer.Public()); err != nil {
+			log.Fatalf("load jwt signing key failed: %v", err)
+		}
+		keys.signingKey = signer
+		if keys.keyId == "" {
+			keys.keyId = thumbprint(signer.Public())
+		}
+		keys.verifyKeys[keys.keyId] = signer.Public()
+	default:
+		log.Fatalf("load jwt signing method failed: unknown method %s", keys.signingMethod)
+	}
+
+	for _, item := range strings.Split(envMap["JWT_VERIFY_KEY_FILES"], ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kid, path, ok := strings.Cut(item, ":")
+		if !ok || kid == "" || path == "" {
+			log.Fatalf("load jwt verify keys failed: %s is not kid:path", item)
+		}
+		pub, err := loadPublicKey(path)
+		if err != nil {
+			log.Fatalf("load jwt verify key %s failed: %v", kid, err)
+		}
+		if _, ok := keys.verifyKeys[kid]; ok {
+			log.Fatalf("load jwt verify key %s failed: kid is duplicated", kid)
+		}
+		keys.verifyKeys[kid] = pub
+	}
+	return keys
+}
+
+func readPem(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a pem file", path)
+	}
+	return block, nil
+}
+
+func loadPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPem(path)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("key type is not supported")
+	}
+	return signer, nil
+}
+
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPem(path)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("key type is not supported")
+	}
+}
+
+func checkKeyType(method string, pub crypto.PublicKey) error {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		if method == "RS256" {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if method == "EdDSA" {
+			return nil
+		}
+	}
+	return fmt.Errorf("key type does not match %s", method)
+}
+
+// thumbprint is the default kid, stable for the same key between restarts.
+func thumbprint(pub crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		log.Fatalf("marshal jwt public key failed: %v", err)
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+type IOidcProviderConfig interface {
+	Name() string
+	Issuer() string
+	ClientId() string
+	ClientSecret() string
+	RedirectUrl() string
+	Scopes() []string
+}
+
+type oidcProvider struct {
+	name         string
+	issuer       string
+	clientId     string
+	clientSecret string
+	redirectUrl  string
+	scopes       []string
+}
+
+func (p *oidcProvider) Name() string         { return p.name }
+func (p *oidcProvider) Issuer() string       { return p.issuer }
+func (p *oidcProvider) ClientId() string     { return p.clientId }
+func (p *oidcProvider) ClientSecret() string { return p.clientSecret }
+func (p *oidcProvider) RedirectUrl() string  { return p.redirectUrl }
+func (p *oidcProvider) Scopes() []string     { return p.scopes }
+
+// loadOidc reads the providers named in OIDC_PROVIDERS, each one configured
+// by OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET, _SCOPES and _REDIRECT_URL.
+func loadOidc(envMap map[string]string, clientUrl string) *oidc {
+	o := &oidc{
+		stateExpiresAt: 600,
+		providers:      make(map[string]*oidcProvider),
+	}
+	if envMap["OIDC_STATE_EXPIRES"] != "" {
+		x, err := strconv.Atoi(envMap["OIDC_STATE_EXPIRES"])
+		if err != nil {
+			log.Fatalf("load oidc state expires failed: %v", err)
+		}
+		o.stateExpiresAt = x
+	}
+
+	for _, name := range strings.Split(envMap["OIDC_PROVIDERS"], ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := o.providers[name]; ok {
+			log.Fatalf("load oidc provider %s failed: name is duplicated", name)
+		}
+		prefix := fmt.Sprintf("OIDC_%s_", strings.ToUpper(name))
+
+		p := &oidcProvider{
+			name:         name,
+			issuer:       strings.TrimSuffix(envMap[prefix+"ISSUER"], "/"),
+			clientId:     envMap[prefix+"CLIENT_ID"],
+			clientSecret: envMap[prefix+"CLIENT_SECRET"],
+			redirectUrl:  envMap[prefix+"REDIRECT_URL"],
+			scopes:       strings.Fields(envMap[prefix+"SCOPES"]),
+		}
+		if p.issuer == "" || p.clientId == "" {
+			log.Fatalf("load oidc provider %s failed: %sISSUER and %sCLIENT_ID are required", name, prefix, prefix)
+		}
+		if p.redirectUrl == "" {
+			p.redirectUrl = fmt.Sprintf("%s/oidc/%s/callback", clientUrl, name)
+		}
+		if len(p.scopes) == 0 {
+			p.scopes = []string{"openid", "email", "profile"}
+		}
+		o.providers[name] = p
+		o.names = append(o.names, name)
+	}
+	return o
+}
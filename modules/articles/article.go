@@ -1,5 +1,25 @@
 package articles
 
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NattpkJsw/real-world-api-go/pkg/diff"
+)
+
+type Status string
+
+const (
+	Draft Status = "draft"
+	// Scheduled articles are published by a background job at publish_at
+	Scheduled Status = "scheduled"
+	Published Status = "published"
+	Archived  Status = "archived"
+)
+
 type Article struct {
 	Slug           *string   `json:"slug"`
 	Title          *string   `json:"title"`
@@ -8,9 +28,13 @@ type Article struct {
 	TagList        *[]string `json:"taglist"`
 	CreatedAt      *string   `json:"createdAt"`
 	UpdatedAt      *string   `json:"updatedAt"`
+	Status         *string   `json:"status"`
+	PublishAt      *string   `json:"publishAt"`
 	Favorited      *bool     `json:"favorited"`
 	FavoritesCount *int      `json:"favoritesCount"`
 	Author         *Author   `json:"author"`
+	// Snippet is the matched text of a search with the terms in <mark>
+	Snippet *string `json:"snippet,omitempty"`
 }
 
 type JSONArticle struct {
@@ -27,6 +51,12 @@ type Author struct {
 type ArticleList struct {
 	Article       []*Article `json:"articles"`
 	ArticlesCount int        `json:"articlesCount"`
+	Limit         int        `json:"limit"`
+	Offset        int        `json:"offset"`
+	HasMore       bool       `json:"hasMore"`
+	// NextCursor continues the list after its last article, it is empty
+	// once the list runs out
+	NextCursor string `json:"nextCursor,omitempty"`
 }
 
 type ArticleFilter struct {
@@ -36,21 +66,67 @@ type ArticleFilter struct {
 	Limit     int    `query:"limit"`
 	Offset    int    `query:"offset"`
 	IsFeed    bool   `query:"isfeed"`
+	Query     string `query:"q"`
+	Cursor    string `query:"cursor"`
+	// Drafts lists the unpublished articles of the user instead
+	Drafts bool `query:"-"`
+	// After is the decoded cursor, it takes over from the offset
+	After *Cursor `query:"-"`
 }
 
 type ArticleFeedFilter struct {
-	Limit  int `query:"limit"`
-	Offset int `query:"offset"`
+	Limit  int     `query:"limit"`
+	Offset int     `query:"offset"`
+	Cursor string  `query:"cursor"`
+	After  *Cursor `query:"-"`
+}
+
+// Cursor is the key of the last article of a page, the lists are ordered
+// by publish time, drafts by creation time, and then id so the pair is unique.
+type Cursor struct {
+	Time string
+	Id   int
+}
+
+const cursorTimeLayout = "2006-01-02T15:04:05.999999"
+
+func EncodeCursor(at string, id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(at + "|" + strconv.Itoa(id)))
+}
+
+// DecodeCursor answers nil for an empty cursor.
+func DecodeCursor(cursor string) (*Cursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("cursor is invalid")
+	}
+	at, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, fmt.Errorf("cursor is invalid")
+	}
+	if _, err := time.Parse(cursorTimeLayout, at); err != nil {
+		return nil, fmt.Errorf("cursor is invalid")
+	}
+	result := &Cursor{Time: at}
+	if result.Id, err = strconv.Atoi(id); err != nil || result.Id <= 0 {
+		return nil, fmt.Errorf("cursor is invalid")
+	}
+	return result, nil
 }
 
 type ArticleCredential struct {
-	Id          int       `json:"id"`
-	Author      int       `json:"author_id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Body        string    `json:"body"`
-	TagList     []*string `json:"tagList"`
-	Slug        string    `json:"slug"`
+	Id          int        `json:"id"`
+	Author      int        `json:"author_id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Body        string     `json:"body"`
+	TagList     []*string  `json:"tagList"`
+	Slug        string     `json:"slug"`
+	Status      Status     `json:"status"`
+	PublishAt   *time.Time `json:"publishAt"`
 }
 
 type JSONArticleCredential struct {
@@ -60,3 +136,41 @@ type JSONArticleCredential struct {
 type TagList struct {
 	Tags []string `json:"tags"`
 }
+
+type Revision struct {
+	Revision    int     `db:"revision" json:"revision"`
+	Title       string  `db:"title" json:"title"`
+	Description string  `db:"description" json:"description"`
+	Body        string  `db:"body" json:"body"`
+	Editor      *string `db:"editor" json:"editor"`
+	CreatedAt   string  `db:"createdat" json:"createdAt"`
+}
+
+type JSONRevision struct {
+	Revision *Revision `json:"revision"`
+}
+
+type RevisionSummary struct {
+	Revision  int     `db:"revision" json:"revision"`
+	Title     string  `db:"title" json:"title"`
+	Editor    *string `db:"editor" json:"editor"`
+	CreatedAt string  `db:"createdat" json:"createdAt"`
+}
+
+type JSONRevisions struct {
+	Revisions []*RevisionSummary `json:"revisions"`
+}
+
+// RevisionDiff compares two revisions field by field, revision 0 stands for
+// the empty article before the first one.
+type RevisionDiff struct {
+	From        int         `json:"from"`
+	To          int         `json:"to"`
+	Title       []diff.Line `json:"title"`
+	Description []diff.Line `json:"description"`
+	Body        []diff.Line `json:"body"`
+}
+
+type JSONRevisionDiff struct {
+	Diff *RevisionDiff `json:"diff"`
+}
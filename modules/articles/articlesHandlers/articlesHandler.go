@@ -3,12 +3,14 @@ package articleshandlers
 import (
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/NattpkJsw/real-world-api-go/config"
 	"github.com/NattpkJsw/real-world-api-go/modules/articles"
 	articlesusecases "github.com/NattpkJsw/real-world-api-go/modules/articles/articlesUsecases"
 	"github.com/NattpkJsw/real-world-api-go/modules/entities"
+	"github.com/NattpkJsw/real-world-api-go/modules/users"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -24,6 +26,12 @@ const (
 	favoriteArticleErr   articlesHandlersErrCode = "article-007"
 	unfavoriteArticleErr articlesHandlersErrCode = "article-008"
 	getTagslistErr       articlesHandlersErrCode = "article-009"
+	getDraftsErr         articlesHandlersErrCode = "article-010"
+	getRevisionsErr      articlesHandlersErrCode = "article-011"
+	getRevisionErr       articlesHandlersErrCode = "article-012"
+	getRevisionDiffErr   articlesHandlersErrCode = "article-013"
+	restoreRevisionErr   articlesHandlersErrCode = "article-014"
+	searchArticlesErr    articlesHandlersErrCode = "article-015"
 )
 
 type IArticleshandler interface {
@@ -36,6 +44,12 @@ type IArticleshandler interface {
 	FavoriteArticle(c *fiber.Ctx) error
 	UnfavoriteArticle(c *fiber.Ctx) error
 	GetTagsList(c *fiber.Ctx) error
+	GetDrafts(c *fiber.Ctx) error
+	GetRevisions(c *fiber.Ctx) error
+	GetRevision(c *fiber.Ctx) error
+	GetRevisionDiff(c *fiber.Ctx) error
+	RestoreRevision(c *fiber.Ctx) error
+	SearchArticles(c *fiber.Ctx) error
 }
 
 type articlesHandler struct {
@@ -92,6 +106,15 @@ func (h *articlesHandler) GetArticlesList(c *fiber.Ctx) error {
 	if req.Offset <= 0 {
 		req.Offset = 0
 	}
+	after, err := articles.DecodeCursor(req.Cursor)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(getArticlesErr),
+			err.Error(),
+		).Res()
+	}
+	req.After = after
 
 	articlesOut, err := h.articlesUsecase.GetArticlesList(req, userId)
 	if err != nil {
@@ -101,10 +124,101 @@ func (h *articlesHandler) GetArticlesList(c *fiber.Ctx) error {
 			err.Error(),
 		).Res()
 	}
+	setPageLinks(c, articlesOut)
 	return entities.NewResponse(c).Success(fiber.StatusOK, articlesOut).Res()
 
 }
 
+// setPageLinks adds the RFC 8288 Link header for the pages around a list.
+// The next page follows the cursor when the list has one, the other pages
+// are addressed by offset.
+func setPageLinks(c *fiber.Ctx, list *articles.ArticleList) {
+	if list == nil || list.Limit <= 0 {
+		return
+	}
+	query, err := url.ParseQuery(string(c.Request().URI().QueryString()))
+	if err != nil {
+		return
+	}
+	link := func(rel string, set func(url.Values)) string {
+		page := url.Values{}
+		for k, v := range query {
+			page[k] = v
+		}
+		page.Del("cursor")
+		page.Del("offset")
+		page.Set("limit", strconv.Itoa(list.Limit))
+		set(page)
+		return fmt.Sprintf(`<%s%s?%s>; rel="%s"`, c.BaseURL(), c.Path(), page.Encode(), rel)
+	}
+	atOffset := func(offset int) func(url.Values) {
+		return func(page url.Values) {
+			page.Set("offset", strconv.Itoa(offset))
+		}
+	}
+
+	links := make([]string, 0, 4)
+	if list.HasMore {
+		if list.NextCursor != "" {
+			links = append(links, link("next", func(page url.Values) {
+				page.Set("cursor", list.NextCursor)
+			}))
+		} else {
+			links = append(links, link("next", atOffset(list.Offset+list.Limit)))
+		}
+	}
+	if list.Offset > 0 {
+		links = append(links, link("prev", atOffset(max(list.Offset-list.Limit, 0))))
+	}
+	links = append(links, link("first", atOffset(0)))
+	last := 0
+	if list.ArticlesCount > 0 {
+		last = (list.ArticlesCount - 1) / list.Limit * list.Limit
+	}
+	links = append(links, link("last", atOffset(last)))
+
+	c.Set(fiber.HeaderLink, strings.Join(links, ", "))
+}
+
+func (h *articlesHandler) SearchArticles(c *fiber.Ctx) error {
+	req := &articles.ArticleFilter{}
+	userId := c.Locals("userId").(int)
+
+	if err := c.QueryParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(searchArticlesErr),
+			err.Error(),
+		).Res()
+	}
+
+	req.Query = strings.TrimSpace(req.Query)
+	if req.Query == "" {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(searchArticlesErr),
+			"search query is required",
+		).Res()
+	}
+	if req.Limit <= 0 {
+		req.Limit = 20
+	}
+	if req.Offset <= 0 {
+		req.Offset = 0
+	}
+
+	articlesOut, err := h.articlesUsecase.GetArticlesList(req, userId)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(searchArticlesErr),
+			err.Error(),
+		).Res()
+	}
+	setPageLinks(c, articlesOut)
+	return entities.NewResponse(c).Success(fiber.StatusOK, articlesOut).Res()
+}
+
 func (h *articlesHandler) GetArticlesFeed(c *fiber.Ctx) error {
 	req := &articles.ArticleFeedFilter{}
 	userId := c.Locals("userId").(int)
@@ -123,6 +237,15 @@ func (h *articlesHandler) GetArticlesFeed(c *fiber.Ctx) error {
 	if req.Offset <= 0 {
 		req.Offset = 0
 	}
+	after, err := articles.DecodeCursor(req.Cursor)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(getArticlesFeedErr),
+			err.Error(),
+		).Res()
+	}
+	req.After = after
 	articlesOut, err := h.articlesUsecase.GetArticlesFeed(req, userId)
 
 	if err != nil {
@@ -132,6 +255,7 @@ func (h *articlesHandler) GetArticlesFeed(c *fiber.Ctx) error {
 			err.Error(),
 		).Res()
 	}
+	setPageLinks(c, articlesOut)
 	return entities.NewResponse(c).Success(fiber.StatusOK, articlesOut).Res()
 
 }
@@ -151,11 +275,20 @@ func (h *articlesHandler) CreateArticle(c *fiber.Ctx) error {
 
 	article, err := h.articlesUsecase.CreateArticle(req.Article)
 	if err != nil {
-		return entities.NewResponse(c).Error(
-			fiber.ErrInternalServerError.Code,
-			string(createArticleErr),
-			err.Error(),
-		).Res()
+		switch err.Error() {
+		case "status is invalid", "publish time is required", "publish time must be in the future":
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(createArticleErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(createArticleErr),
+				err.Error(),
+			).Res()
+		}
 	}
 	return entities.NewResponse(c).Success(fiber.StatusCreated, article).Res()
 }
@@ -181,13 +314,30 @@ func (h *articlesHandler) UpdateArticle(c *fiber.Ctx) error {
 	}
 	req.Article.Slug = slug
 
-	article, err := h.articlesUsecase.UpdateArticle(req.Article, userID)
+	role, _ := c.Locals("userRole").(users.Role)
+	article, err := h.articlesUsecase.UpdateArticle(req.Article, userID, role)
 	if err != nil {
-		return entities.NewResponse(c).Error(
-			fiber.ErrInternalServerError.Code,
-			string(updateArticleErr),
-			err.Error(),
-		).Res()
+		switch err.Error() {
+		case "status is invalid", "publish time is required", "publish time must be in the future",
+			"published article cannot be scheduled":
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(updateArticleErr),
+				err.Error(),
+			).Res()
+		case "title has been used":
+			return entities.NewResponse(c).Error(
+				fiber.ErrConflict.Code,
+				string(updateArticleErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(updateArticleErr),
+				err.Error(),
+			).Res()
+		}
 	}
 
 	return entities.NewResponse(c).Success(fiber.StatusOK, article).Res()
@@ -204,7 +354,8 @@ func (h *articlesHandler) DeleteArticle(c *fiber.Ctx) error {
 			err.Error(),
 		).Res()
 	}
-	if err := h.articlesUsecase.DeleteArticle(slug, userId); err != nil {
+	role, _ := c.Locals("userRole").(users.Role)
+	if err := h.articlesUsecase.DeleteArticle(slug, userId, role); err != nil {
 		return entities.NewResponse(c).Error(
 			fiber.ErrInternalServerError.Code,
 			string(deleteArticleErr),
@@ -278,3 +429,167 @@ func (h *articlesHandler) GetTagsList(c *fiber.Ctx) error {
 
 	return entities.NewResponse(c).Success(fiber.StatusOK, tagsList).Res()
 }
+
+func (h *articlesHandler) GetDrafts(c *fiber.Ctx) error {
+	req := &articles.ArticleFeedFilter{}
+	userId := c.Locals("userId").(int)
+
+	if err := c.QueryParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(getDraftsErr),
+			err.Error(),
+		).Res()
+	}
+
+	if req.Limit <= 0 {
+		req.Limit = 20
+	}
+	if req.Offset <= 0 {
+		req.Offset = 0
+	}
+	after, err := articles.DecodeCursor(req.Cursor)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(getDraftsErr),
+			err.Error(),
+		).Res()
+	}
+	req.After = after
+
+	articlesOut, err := h.articlesUsecase.GetDrafts(req, userId)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(getDraftsErr),
+			err.Error(),
+		).Res()
+	}
+	setPageLinks(c, articlesOut)
+	return entities.NewResponse(c).Success(fiber.StatusOK, articlesOut).Res()
+}
+
+func revisionsError(c *fiber.Ctx, errCode articlesHandlersErrCode, err error) error {
+	switch err.Error() {
+	case "only the author can access the revisions":
+		return entities.NewResponse(c).Error(
+			fiber.ErrForbidden.Code,
+			string(errCode),
+			err.Error(),
+		).Res()
+	case "revision not found":
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(errCode),
+			err.Error(),
+		).Res()
+	case "title has been used":
+		return entities.NewResponse(c).Error(
+			fiber.ErrConflict.Code,
+			string(errCode),
+			err.Error(),
+		).Res()
+	default:
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(errCode),
+			err.Error(),
+		).Res()
+	}
+}
+
+// revisionParams reads the slug and the revision number of the path.
+func revisionParams(c *fiber.Ctx) (string, int, error) {
+	slug, err := url.PathUnescape(strings.TrimSpace(c.Params("slug")))
+	if err != nil {
+		return "", 0, err
+	}
+	revision, err := strconv.Atoi(c.Params("n"))
+	if err != nil || revision < 1 {
+		return "", 0, fmt.Errorf("revision is invalid")
+	}
+	return slug, revision, nil
+}
+
+func (h *articlesHandler) GetRevisions(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	slug, err := url.PathUnescape(strings.TrimSpace(c.Params("slug")))
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(getRevisionsErr),
+			err.Error(),
+		).Res()
+	}
+
+	result, err := h.articlesUsecase.GetRevisions(slug, userId)
+	if err != nil {
+		return revisionsError(c, getRevisionsErr, err)
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, result).Res()
+}
+
+func (h *articlesHandler) GetRevision(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	slug, revision, err := revisionParams(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(getRevisionErr),
+			err.Error(),
+		).Res()
+	}
+
+	result, err := h.articlesUsecase.GetRevision(slug, userId, revision)
+	if err != nil {
+		return revisionsError(c, getRevisionErr, err)
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, result).Res()
+}
+
+// GetRevisionDiff compares the revision with the one given by ?from=,
+// the revision right before it by default.
+func (h *articlesHandler) GetRevisionDiff(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	slug, revision, err := revisionParams(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(getRevisionDiffErr),
+			err.Error(),
+		).Res()
+	}
+	from := c.QueryInt("from", revision-1)
+	if from < 0 {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(getRevisionDiffErr),
+			"revision is invalid",
+		).Res()
+	}
+
+	result, err := h.articlesUsecase.GetRevisionDiff(slug, userId, from, revision)
+	if err != nil {
+		return revisionsError(c, getRevisionDiffErr, err)
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, result).Res()
+}
+
+func (h *articlesHandler) RestoreRevision(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	slug, revision, err := revisionParams(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(restoreRevisionErr),
+			err.Error(),
+		).Res()
+	}
+
+	result, err := h.articlesUsecase.RestoreRevision(slug, userId, revision)
+	if err != nil {
+		return revisionsError(c, restoreRevisionErr, err)
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, result).Res()
+}
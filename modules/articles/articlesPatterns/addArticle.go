@@ -14,6 +14,7 @@ type IAddArticleBuilder interface {
 	initTransaction() error
 	addArticle() error
 	addTag(req []*string, articleId int) error
+	addRevision() error
 	commit() error
 	getArticleId() int
 }
@@ -48,6 +49,9 @@ func (en *addArticleEngineer) AddArticle() (int, error) {
 	if err := en.builder.addArticle(); err != nil {
 		return 0, err
 	}
+	if err := en.builder.addRevision(); err != nil {
+		return 0, err
+	}
 	if err := en.builder.commit(); err != nil {
 		return 0, err
 	}
@@ -77,9 +81,11 @@ func (b *addArticleBuilder) addArticle() error {
 		"slug",
 		"description",
 		"body",
-		"author_id"
+		"author_id",
+		"status",
+		"publish_at"
 	)
-	VALUES ($1, $1, $2, $3, $4)
+	VALUES ($1, $1, $2, $3, $4, $5, $6)
 	ON CONFLICT ("title", "slug") DO NOTHING
 	RETURNING "id";`
 	if err := b.tx.QueryRowxContext(
@@ -89,6 +95,8 @@ func (b *addArticleBuilder) addArticle() error {
 		b.req.Description,
 		b.req.Body,
 		b.req.Author,
+		b.req.Status,
+		b.req.PublishAt,
 	).Scan(&b.req.Id); err != nil {
 		b.tx.Rollback()
 		return fmt.Errorf("insert article failed: %v", err)
@@ -157,6 +165,34 @@ func (b *addArticleBuilder) addArticleTag(tagId []int, articeId int) error {
 	return nil
 }
 
+// addRevision keeps the content the article starts with as its first
+// revision.
+func (b *addArticleBuilder) addRevision() error {
+	query := `
+	INSERT INTO "article_revisions"(
+		"article_id",
+		"revision",
+		"title",
+		"description",
+		"body",
+		"editor_id"
+	)
+	VALUES ($1, 1, $2, $3, $4, $5);`
+
+	if _, err := b.tx.Exec(
+		query,
+		b.req.Id,
+		b.req.Title,
+		b.req.Description,
+		b.req.Body,
+		b.req.Author,
+	); err != nil {
+		b.tx.Rollback()
+		return fmt.Errorf("insert revision failed: %v", err)
+	}
+	return nil
+}
+
 func (b *addArticleBuilder) commit() error {
 	if err := b.tx.Commit(); err != nil {
 		return fmt.Errorf("commit error: %v", err)
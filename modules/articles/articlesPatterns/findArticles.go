@@ -17,12 +17,16 @@ import (
 type IFindArticleBuilder interface {
 	openJsonQuery(userId int)
 	initQuery()
-	countQuery()
+	countQuery(userId int)
 	whereQuery()
 	sort()
 	closeJsonQuery()
 	resetQuery()
 	Result() ([]*articles.Article, error)
+	Count() (int, error)
+	NextCursor() string
+	HasMore() bool
+	Query() (string, []any)
 	PrintQUery()
 }
 
@@ -32,6 +36,15 @@ type findArticleBuilder struct {
 	query          string
 	lastStackIndex int
 	values         []any
+	searchIndex    int
+	nextCursor     string
+	hasMore        bool
+}
+
+// articleRow carries the id next to the article to build the cursor from.
+type articleRow struct {
+	*articles.Article
+	Id int `json:"id"`
 }
 
 type findArticleEngineer struct {
@@ -58,8 +71,8 @@ func (en *findArticleEngineer) FindArticle(userId int) IFindArticleBuilder {
 	return en.builder
 }
 
-func (en *findArticleEngineer) CountArticle() IFindArticleBuilder {
-	en.builder.countQuery()
+func (en *findArticleEngineer) CountArticle(userId int) IFindArticleBuilder {
+	en.builder.countQuery(userId)
 	en.builder.whereQuery()
 	return en.builder
 }
@@ -77,6 +90,7 @@ func (b *findArticleBuilder) openJsonQuery(userId int) {
 func (b *findArticleBuilder) initQuery() {
 	b.query += `
 		SELECT
+		"a"."id",
 		"a"."slug",
 		"a"."title",
 		"a"."description",
@@ -89,6 +103,8 @@ func (b *findArticleBuilder) initQuery() {
 		) AS "taglist",
 		"a"."createdat",
 		"a"."updatedat",
+		"a"."status",
+		"a"."publish_at" AS "publishAt",
 		(
 			SELECT
 			CASE WHEN EXISTS(
@@ -120,11 +136,39 @@ func (b *findArticleBuilder) initQuery() {
 				)
 			FROM "users" "u"
 			WHERE "a"."author_id" = "u"."id"
-		) AS "author"
+		) AS "author"`
+
+	if b.req.Query != "" {
+		b.query += fmt.Sprintf(`,
+		ts_headline(
+			'english',
+			concat_ws(' ', "a"."description", "a"."body"),
+			websearch_to_tsquery('english', %s),
+			'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10'
+		) AS "snippet"`, b.searchArg())
+	}
+
+	b.query += `
 		FROM "articles" "a"
 		WHERE 1 = 1`
 }
-func (b *findArticleBuilder) countQuery() {
+
+// searchArg adds the search text to the values once and answers its
+// placeholder, so the select, where and sort share it.
+func (b *findArticleBuilder) searchArg() string {
+	if b.searchIndex == 0 {
+		b.values = append(b.values, b.req.Query)
+		b.searchIndex = len(b.values)
+	}
+	return "$" + strconv.Itoa(b.searchIndex)
+}
+func (b *findArticleBuilder) countQuery(userId int) {
+	// $1 is only bound when the where clause refers to the user, postgres
+	// cannot type a parameter the query never uses
+	b.values = make([]any, 0)
+	if b.req.IsFeed || b.req.Drafts {
+		b.values = append(b.values, userId)
+	}
 	b.query += `
 	SELECT
 			COUNT(*) AS "count"
@@ -135,6 +179,13 @@ func (b *findArticleBuilder) whereQuery() {
 	var queryWhere string
 	queryWhereStack := make([]string, 0)
 
+	if b.req.Query != "" {
+		queryWhere += `
+		AND "a"."search" @@ websearch_to_tsquery('english', ` + b.searchArg() + `)`
+	}
+	// The filters below are numbered after the values added so far
+	base := len(b.values)
+
 	if b.req.Tag != "" {
 		b.values = append(b.values, b.req.Tag)
 		queryWhereStack = append(queryWhereStack, ` 
@@ -166,7 +217,7 @@ func (b *findArticleBuilder) whereQuery() {
 	}
 
 	for i := range queryWhereStack {
-		queryWhere += strings.Replace(queryWhereStack[i], "?", "$"+strconv.Itoa(i+2), 1)
+		queryWhere += strings.Replace(queryWhereStack[i], "?", "$"+strconv.Itoa(base+i+1), 1)
 	}
 
 	if b.req.IsFeed {
@@ -178,17 +229,53 @@ func (b *findArticleBuilder) whereQuery() {
 		)`
 	}
 
+	// Only the author gets to see an article before it is published
+	if b.req.Drafts {
+		queryWhere += `
+		AND "a"."author_id" = $1 AND "a"."status" IN ('draft', 'scheduled')`
+	} else {
+		queryWhere += `
+		AND "a"."status" = 'published'`
+	}
+
+	// A search is ordered by rank, so the keyset does not apply to it
+	if b.req.After != nil && b.req.Query == "" {
+		b.values = append(b.values, b.req.After.Time, b.req.After.Id)
+		queryWhere += fmt.Sprintf(`
+		AND (%s, "a"."id") < ($%d::timestamp, $%d)`, b.orderColumn(), len(b.values)-1, len(b.values))
+	}
+
 	b.lastStackIndex = len(b.values)
 	b.query += queryWhere
 
 }
 
+// orderColumn is the time the lists are ordered and keyed by. A published
+// article counts from its publish time, so a scheduled one comes out on top;
+// drafts have none yet and keep their creation time.
+func (b *findArticleBuilder) orderColumn() string {
+	if b.req.Drafts {
+		return `"a"."createdat"`
+	}
+	return `"a"."publish_at"`
+}
+
 func (b *findArticleBuilder) sort() { // sort
-	b.query += ` 
-	ORDER BY "a"."createdat" DESC`
-	//  set offset and limit
-	b.values = append(b.values, b.req.Offset)
-	b.values = append(b.values, b.req.Limit)
+	if b.req.Query != "" {
+		b.query += `
+	ORDER BY ts_rank("a"."search", websearch_to_tsquery('english', ` + b.searchArg() + `)) DESC, ` + b.orderColumn() + ` DESC, "a"."id" DESC`
+	} else {
+		b.query += `
+	ORDER BY ` + b.orderColumn() + ` DESC, "a"."id" DESC`
+	}
+	//  set offset and limit, a cursor already skips to its page
+	offset := b.req.Offset
+	if b.req.After != nil && b.req.Query == "" {
+		offset = 0
+	}
+	b.values = append(b.values, offset)
+	// One more than the page to tell whether another follows it
+	b.values = append(b.values, b.req.Limit+1)
 	b.query += fmt.Sprintf(` OFFSET $%d LIMIT $%d`, b.lastStackIndex+1, b.lastStackIndex+2)
 }
 
@@ -201,6 +288,7 @@ func (b *findArticleBuilder) resetQuery() {
 	b.query = ""
 	b.values = make([]any, 0)
 	b.lastStackIndex = 0
+	b.searchIndex = 0
 }
 
 func (b *findArticleBuilder) Result() ([]*articles.Article, error) {
@@ -208,21 +296,70 @@ func (b *findArticleBuilder) Result() ([]*articles.Article, error) {
 	defer cancel()
 
 	bytes := make([]byte, 0)
-	articelsResult := make([]*articles.Article, 0)
+	rows := make([]*articleRow, 0)
 	if err := b.db.Get(&bytes, b.query, b.values...); err != nil {
 		log.Printf("find articles failed: %v\n", err)
 		return make([]*articles.Article, 0), err
 	}
 
-	if err := json.Unmarshal(bytes, &articelsResult); err != nil {
+	if err := json.Unmarshal(bytes, &rows); err != nil {
 		log.Printf("unmarshal articles failed: %v\n", err)
 		return make([]*articles.Article, 0), err
 	}
 	b.resetQuery()
 
+	b.hasMore = len(rows) > b.req.Limit
+	if b.hasMore {
+		rows = rows[:b.req.Limit]
+	}
+
+	articelsResult := make([]*articles.Article, 0, len(rows))
+	for _, row := range rows {
+		articelsResult = append(articelsResult, row.Article)
+	}
+
+	b.nextCursor = ""
+	if b.hasMore && len(rows) > 0 && b.req.Query == "" {
+		last := rows[len(rows)-1]
+		at := last.PublishAt
+		if b.req.Drafts {
+			at = last.CreatedAt
+		}
+		if at != nil {
+			b.nextCursor = articles.EncodeCursor(*at, last.Id)
+		}
+	}
+
 	return articelsResult, nil
 }
 
+func (b *findArticleBuilder) Count() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	var count int
+	if err := b.db.GetContext(ctx, &count, b.query, b.values...); err != nil {
+		log.Printf("count articles failed: %v\n", err)
+		return 0, err
+	}
+	b.resetQuery()
+
+	return count, nil
+}
+
+func (b *findArticleBuilder) NextCursor() string {
+	return b.nextCursor
+}
+
+func (b *findArticleBuilder) HasMore() bool {
+	return b.hasMore
+}
+
+// Query answers the statement built so far with its values.
+func (b *findArticleBuilder) Query() (string, []any) {
+	return b.query, b.values
+}
+
 func (b *findArticleBuilder) PrintQUery() {
 	utils.Debug(b.values)
 	fmt.Println(b.query)
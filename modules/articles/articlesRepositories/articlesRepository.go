@@ -13,14 +13,19 @@ import (
 
 type IArticlesRepository interface {
 	GetSingleArticle(articleId int, userId int) (*articles.Article, error)
-	GetArticlesList(req *articles.ArticleFilter, userId int) ([]*articles.Article, int, error)
-	GetArticleIdBySlug(slug string) (int, error)
+	GetArticlesList(req *articles.ArticleFilter, userId int) (*articles.ArticleList, error)
+	GetArticleIdBySlug(slug string, userId int) (int, error)
 	CreateArticle(req *articles.ArticleCredential) (*articles.Article, error)
-	UpdateArticle(req *articles.ArticleCredential, userID int) (*articles.Article, error)
-	DeleteArticle(articleID, userID int) error
+	UpdateArticle(req *articles.ArticleCredential, userID int, canModerate bool) (*articles.Article, error)
+	DeleteArticle(articleID, userID int, canModerate bool) error
 	FavoriteArticle(userID, articleID int) (*articles.Article, error)
 	UnfavoriteArticle(userID, articleID int) (*articles.Article, error)
 	GetTagsList() (*articles.TagList, error)
+	PublishScheduledArticles() (int, error)
+	GetArticleAuthorId(articleId int) (int, error)
+	FindRevisions(articleId int) ([]*articles.RevisionSummary, error)
+	FindRevision(articleId, revision int) (*articles.Revision, error)
+	RestoreRevision(articleId, revision, userId int) (*articles.Article, error)
 }
 
 type articlesRepository struct {
@@ -51,6 +56,8 @@ func (r *articlesRepository) GetSingleArticle(articleId int, userId int) (*artic
 			) AS "taglist",
 			"a"."createdat",
 			"a"."updatedat",
+			"a"."status",
+			"a"."publish_at" AS "publishAt",
 			(
 				SELECT
 				CASE WHEN EXISTS(
@@ -84,7 +91,7 @@ func (r *articlesRepository) GetSingleArticle(articleId int, userId int) (*artic
 				WHERE "a"."author_id" = "u"."id"
 			) AS "author"
 			FROM "articles" "a"
-			WHERE "a"."id" = $1
+			WHERE "a"."id" = $1 AND ("a"."status" = 'published' OR "a"."author_id" = $2)
 			LIMIT 1
 	) AS "ar";`
 
@@ -100,27 +107,51 @@ func (r *articlesRepository) GetSingleArticle(articleId int, userId int) (*artic
 	return article, nil
 }
 
-func (r *articlesRepository) GetArticleIdBySlug(slug string) (int, error) {
+// GetArticleIdBySlug does not find the unpublished articles of other users,
+// to them the article does not exist yet.
+func (r *articlesRepository) GetArticleIdBySlug(slug string, userId int) (int, error) {
 	query := `
 	SELECT "a"."id"
 	FROM "articles" "a"
-	WHERE "a"."slug" = $1`
+	WHERE "a"."slug" = $1 AND ("a"."status" = 'published' OR "a"."author_id" = $2)`
 
 	var id int
-	if err := r.db.Get(&id, query, slug); err != nil {
+	if err := r.db.Get(&id, query, slug, userId); err != nil {
 		return 0, fmt.Errorf("get articleID failed: %v", err)
 	}
 	return id, nil
 }
 
-func (r *articlesRepository) GetArticlesList(req *articles.ArticleFilter, userId int) ([]*articles.Article, int, error) {
+func (r *articlesRepository) GetArticlesList(req *articles.ArticleFilter, userId int) (*articles.ArticleList, error) {
 	builder := articlespatterns.FindArticleBuilder(r.db, req)
 	engineer := articlespatterns.FindProductEngineer(builder)
 
 	result, err := engineer.FindArticle(userId).Result()
-	count := len(result)
+	if err != nil {
+		return nil, err
+	}
 
-	return result, count, err
+	// The total covers the whole list, not just what follows the cursor
+	countReq := *req
+	countReq.After = nil
+	countBuilder := articlespatterns.FindArticleBuilder(r.db, &countReq)
+	count, err := articlespatterns.FindProductEngineer(countBuilder).CountArticle(userId).Count()
+	if err != nil {
+		return nil, err
+	}
+
+	offset := req.Offset
+	if req.After != nil && req.Query == "" {
+		offset = 0
+	}
+	return &articles.ArticleList{
+		Article:       result,
+		ArticlesCount: count,
+		Limit:         req.Limit,
+		Offset:        offset,
+		HasMore:       builder.HasMore(),
+		NextCursor:    builder.NextCursor(),
+	}, nil
 }
 
 func (r *articlesRepository) CreateArticle(req *articles.ArticleCredential) (*articles.Article, error) {
@@ -138,11 +169,13 @@ func (r *articlesRepository) CreateArticle(req *articles.ArticleCredential) (*ar
 	return article, nil
 }
 
-func (r *articlesRepository) UpdateArticle(req *articles.ArticleCredential, userID int) (*articles.Article, error) {
+func (r *articlesRepository) UpdateArticle(req *articles.ArticleCredential, userID int, canModerate bool) (*articles.Article, error) {
 	query := `
 	UPDATE "articles" SET`
 	params := make(map[string]any)
 	params["id"] = req.Id
+	params["author_id"] = userID
+	params["moderator"] = canModerate
 
 	if req.Title != "" {
 		query += " title = :title,"
@@ -161,23 +194,105 @@ func (r *articlesRepository) UpdateArticle(req *articles.ArticleCredential, user
 		params["description"] = req.Description
 	}
 
+	// The publish time goes with the status, a draft or archived article
+	// has none. Publishing again keeps the first publish time.
+	if req.Status != "" {
+		query += " status = :status,"
+		query += " publish_at = CASE WHEN status = 'published' AND :status = 'published' THEN publish_at ELSE :publish_at END,"
+		params["status"] = req.Status
+		params["publish_at"] = req.PublishAt
+	}
+
 	query = query[:len(query)-1]
-	query += " WHERE id = :id;"
+	query += " WHERE id = :id AND (author_id = :author_id OR :moderator);"
 	fmt.Println("query === ", query)
-	if _, err := r.db.NamedExec(query, params); err != nil {
-		return nil, fmt.Errorf("update article failed:%v", err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction failed: %v", err)
+	}
+
+	result, err := tx.NamedExecContext(ctx, query, params)
+	if err != nil {
+		tx.Rollback()
+		switch err.Error() {
+		case "ERROR: duplicate key value violates unique constraint \"articles_slug_key\" (SQLSTATE 23505)",
+			"ERROR: duplicate key value violates unique constraint \"articles_title_key\" (SQLSTATE 23505)",
+			"ERROR: duplicate key value violates unique constraint \"unique_title_slug\" (SQLSTATE 23505)":
+			return nil, fmt.Errorf("title has been used")
+		default:
+			return nil, fmt.Errorf("update article failed:%v", err)
+		}
+	}
+
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("getting number of affected rows failed: %v", err)
+	}
+
+	if rowAffected == 0 {
+		tx.Rollback()
+		return nil, fmt.Errorf("the article doesn't exist")
+	}
+
+	// A status change alone leaves the content, and so the revisions, as is
+	if req.Title != "" || req.Body != "" || req.Description != "" {
+		if err := insertRevision(ctx, tx, req.Id, userID); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
 	}
 
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit error: %v", err)
+	}
 	return r.GetSingleArticle(req.Id, userID)
 }
 
-func (r *articlesRepository) DeleteArticle(articleID, userID int) error {
+// insertRevision copies the current content of the article as its next
+// revision. The update before it holds the row lock, so concurrent edits
+// cannot take the same number.
+func insertRevision(ctx context.Context, tx *sqlx.Tx, articleId, editorId int) error {
+	query := `
+	INSERT INTO "article_revisions"(
+		"article_id",
+		"revision",
+		"title",
+		"description",
+		"body",
+		"editor_id"
+	)
+	SELECT
+		"a"."id",
+		COALESCE((
+			SELECT MAX("r"."revision")
+			FROM "article_revisions" "r"
+			WHERE "r"."article_id" = "a"."id"
+		), 0) + 1,
+		"a"."title",
+		"a"."description",
+		"a"."body",
+		$2
+	FROM "articles" "a"
+	WHERE "a"."id" = $1;`
+
+	if _, err := tx.ExecContext(ctx, query, articleId, editorId); err != nil {
+		return fmt.Errorf("insert revision failed: %v", err)
+	}
+	return nil
+}
+
+func (r *articlesRepository) DeleteArticle(articleID, userID int, canModerate bool) error {
 	query := `
 	DELETE
 	FROM "articles"
-	WHERE "id" = $1 AND "author_id" = $2;`
+	WHERE "id" = $1 AND ("author_id" = $2 OR $3);`
 
-	result, err := r.db.ExecContext(context.Background(), query, articleID, userID)
+	result, err := r.db.ExecContext(context.Background(), query, articleID, userID, canModerate)
 	if err != nil {
 		return fmt.Errorf("delete article failed: %v", err)
 	}
@@ -269,3 +384,129 @@ func (r *articlesRepository) GetTagsList() (*articles.TagList, error) {
 
 	return tagsResult, nil
 }
+
+// PublishScheduledArticles publishes every scheduled article whose time has
+// come and answers how many it published.
+func (r *articlesRepository) PublishScheduledArticles() (int, error) {
+	query := `
+	UPDATE "articles" SET
+		"status" = 'published'
+	WHERE "status" = 'scheduled' AND "publish_at" <= CURRENT_TIMESTAMP;`
+
+	result, err := r.db.ExecContext(context.Background(), query)
+	if err != nil {
+		return 0, fmt.Errorf("publish scheduled articles failed: %v", err)
+	}
+
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("getting number of affected rows failed: %v", err)
+	}
+	return int(rowAffected), nil
+}
+
+func (r *articlesRepository) GetArticleAuthorId(articleId int) (int, error) {
+	query := `
+	SELECT "a"."author_id"
+	FROM "articles" "a"
+	WHERE "a"."id" = $1;`
+
+	var authorId int
+	if err := r.db.Get(&authorId, query, articleId); err != nil {
+		return 0, fmt.Errorf("get article author failed: %v", err)
+	}
+	return authorId, nil
+}
+
+func (r *articlesRepository) FindRevisions(articleId int) ([]*articles.RevisionSummary, error) {
+	query := `
+	SELECT
+		"r"."revision",
+		"r"."title",
+		"u"."username" AS "editor",
+		"r"."createdat"
+	FROM "article_revisions" "r"
+	LEFT JOIN "users" "u" ON "u"."id" = "r"."editor_id"
+	WHERE "r"."article_id" = $1
+	ORDER BY "r"."revision" DESC;`
+
+	revisions := make([]*articles.RevisionSummary, 0)
+	if err := r.db.Select(&revisions, query, articleId); err != nil {
+		return nil, fmt.Errorf("get revisions failed: %v", err)
+	}
+	return revisions, nil
+}
+
+func (r *articlesRepository) FindRevision(articleId, revision int) (*articles.Revision, error) {
+	query := `
+	SELECT
+		"r"."revision",
+		"r"."title",
+		COALESCE("r"."description", '') AS "description",
+		COALESCE("r"."body", '') AS "body",
+		"u"."username" AS "editor",
+		"r"."createdat"
+	FROM "article_revisions" "r"
+	LEFT JOIN "users" "u" ON "u"."id" = "r"."editor_id"
+	WHERE "r"."article_id" = $1 AND "r"."revision" = $2;`
+
+	result := new(articles.Revision)
+	if err := r.db.Get(result, query, articleId, revision); err != nil {
+		return nil, fmt.Errorf("revision not found")
+	}
+	return result, nil
+}
+
+// RestoreRevision puts the content of an old revision back, recorded as a
+// new revision so the history only ever grows.
+func (r *articlesRepository) RestoreRevision(articleId, revision, userId int) (*articles.Article, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction failed: %v", err)
+	}
+
+	query := `
+	UPDATE "articles" "a" SET
+		"title" = "r"."title",
+		"slug" = "r"."title",
+		"description" = "r"."description",
+		"body" = "r"."body"
+	FROM "article_revisions" "r"
+	WHERE "a"."id" = $1 AND "r"."article_id" = "a"."id" AND "r"."revision" = $2;`
+
+	result, err := tx.ExecContext(ctx, query, articleId, revision)
+	if err != nil {
+		tx.Rollback()
+		// Another article may have taken the title since the revision
+		switch err.Error() {
+		case "ERROR: duplicate key value violates unique constraint \"articles_slug_key\" (SQLSTATE 23505)",
+			"ERROR: duplicate key value violates unique constraint \"articles_title_key\" (SQLSTATE 23505)",
+			"ERROR: duplicate key value violates unique constraint \"unique_title_slug\" (SQLSTATE 23505)":
+			return nil, fmt.Errorf("title has been used")
+		default:
+			return nil, fmt.Errorf("restore revision failed: %v", err)
+		}
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("getting number of affected rows failed: %v", err)
+	}
+	if rowAffected == 0 {
+		tx.Rollback()
+		return nil, fmt.Errorf("revision not found")
+	}
+
+	if err := insertRevision(ctx, tx, articleId, userId); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit error: %v", err)
+	}
+	return r.GetSingleArticle(articleId, userId)
+}
@@ -1,9 +1,16 @@
 package articlesusecases
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
 	"github.com/NattpkJsw/real-world-api-go/config"
 	"github.com/NattpkJsw/real-world-api-go/modules/articles"
 	articlesrepositories "github.com/NattpkJsw/real-world-api-go/modules/articles/articlesRepositories"
+	"github.com/NattpkJsw/real-world-api-go/modules/users"
+	"github.com/NattpkJsw/real-world-api-go/pkg/diff"
 )
 
 type IArticlesUsecase interface {
@@ -11,11 +18,17 @@ type IArticlesUsecase interface {
 	GetArticlesList(req *articles.ArticleFilter, userId int) (*articles.ArticleList, error)
 	GetArticlesFeed(req *articles.ArticleFeedFilter, userId int) (*articles.ArticleList, error)
 	CreateArticle(req *articles.ArticleCredential) (*articles.JSONArticle, error)
-	UpdateArticle(req *articles.ArticleCredential, userID int) (*articles.JSONArticle, error)
-	DeleteArticle(slug string, userID int) error
+	UpdateArticle(req *articles.ArticleCredential, userID int, role users.Role) (*articles.JSONArticle, error)
+	DeleteArticle(slug string, userID int, role users.Role) error
 	FavoriteArticle(slug string, userID int) (*articles.JSONArticle, error)
 	UnfavoriteArticle(slug string, userID int) (*articles.JSONArticle, error)
 	GetTagsList() (*articles.TagList, error)
+	GetDrafts(req *articles.ArticleFeedFilter, userId int) (*articles.ArticleList, error)
+	PublishScheduledArticles(ctx context.Context) error
+	GetRevisions(slug string, userId int) (*articles.JSONRevisions, error)
+	GetRevision(slug string, userId, revision int) (*articles.JSONRevision, error)
+	GetRevisionDiff(slug string, userId, from, to int) (*articles.JSONRevisionDiff, error)
+	RestoreRevision(slug string, userId, revision int) (*articles.JSONArticle, error)
 }
 
 type articlesUsecase struct {
@@ -31,7 +44,7 @@ func ArticlesUsecase(cfg config.IConfig, articlesRepository articlesrepositories
 }
 
 func (u *articlesUsecase) GetSingleArticle(slug string, userId int) (*articles.JSONArticle, error) {
-	articleId, err := u.articlesRepository.GetArticleIdBySlug(slug)
+	articleId, err := u.articlesRepository.GetArticleIdBySlug(slug, userId)
 	if err != nil {
 		return nil, err
 	}
@@ -47,33 +60,67 @@ func (u *articlesUsecase) GetSingleArticle(slug string, userId int) (*articles.J
 }
 
 func (u *articlesUsecase) GetArticlesList(req *articles.ArticleFilter, userId int) (*articles.ArticleList, error) {
-	articleList, count, err := u.articlesRepository.GetArticlesList(req, userId)
-
-	articlesOut := &articles.ArticleList{
-		Article:       articleList,
-		ArticlesCount: count,
-	}
-
-	return articlesOut, err
+	return u.articlesRepository.GetArticlesList(req, userId)
 }
 
 func (u *articlesUsecase) GetArticlesFeed(req *articles.ArticleFeedFilter, userId int) (*articles.ArticleList, error) {
 	input := &articles.ArticleFilter{
 		Limit:  req.Limit,
 		Offset: req.Offset,
+		After:  req.After,
 		IsFeed: true,
 	}
-	articleList, count, err := u.articlesRepository.GetArticlesList(input, userId)
+	return u.articlesRepository.GetArticlesList(input, userId)
+}
 
-	articlesOut := &articles.ArticleList{
-		Article:       articleList,
-		ArticlesCount: count,
+func (u *articlesUsecase) GetDrafts(req *articles.ArticleFeedFilter, userId int) (*articles.ArticleList, error) {
+	input := &articles.ArticleFilter{
+		Limit:  req.Limit,
+		Offset: req.Offset,
+		After:  req.After,
+		Drafts: true,
 	}
+	return u.articlesRepository.GetArticlesList(input, userId)
+}
 
-	return articlesOut, err
+// applyStatus settles the status against the publish time. An article given
+// only a publish time is scheduled, a new one given neither is published
+// right away like before drafts existed.
+func applyStatus(req *articles.ArticleCredential, creating bool) error {
+	if req.Status == "" {
+		switch {
+		case req.PublishAt != nil:
+			req.Status = articles.Scheduled
+		case creating:
+			req.Status = articles.Published
+		default:
+			return nil
+		}
+	}
+
+	switch req.Status {
+	case articles.Scheduled:
+		if req.PublishAt == nil {
+			return fmt.Errorf("publish time is required")
+		}
+		if !req.PublishAt.After(time.Now()) {
+			return fmt.Errorf("publish time must be in the future")
+		}
+	case articles.Published:
+		now := time.Now()
+		req.PublishAt = &now
+	case articles.Draft, articles.Archived:
+		req.PublishAt = nil
+	default:
+		return fmt.Errorf("status is invalid")
+	}
+	return nil
 }
 
 func (u *articlesUsecase) CreateArticle(req *articles.ArticleCredential) (*articles.JSONArticle, error) {
+	if err := applyStatus(req, true); err != nil {
+		return nil, err
+	}
 	article, err := u.articlesRepository.CreateArticle(req)
 	if err != nil {
 		return nil, err
@@ -86,14 +133,30 @@ func (u *articlesUsecase) CreateArticle(req *articles.ArticleCredential) (*artic
 
 }
 
-func (u *articlesUsecase) UpdateArticle(req *articles.ArticleCredential, userID int) (*articles.JSONArticle, error) {
-	articleID, err := u.articlesRepository.GetArticleIdBySlug(req.Slug)
+func (u *articlesUsecase) UpdateArticle(req *articles.ArticleCredential, userID int, role users.Role) (*articles.JSONArticle, error) {
+	// A publish time alone means scheduling, which would take a published
+	// article down until then
+	scheduling := req.Status == "" && req.PublishAt != nil
+	if err := applyStatus(req, false); err != nil {
+		return nil, err
+	}
+	articleID, err := u.articlesRepository.GetArticleIdBySlug(req.Slug, userID)
 	if err != nil {
 		return nil, err
 	}
 	req.Id = articleID
 
-	article, err := u.articlesRepository.UpdateArticle(req, userID)
+	if scheduling {
+		current, err := u.articlesRepository.GetSingleArticle(articleID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if current.Status != nil && articles.Status(*current.Status) == articles.Published {
+			return nil, fmt.Errorf("published article cannot be scheduled")
+		}
+	}
+
+	article, err := u.articlesRepository.UpdateArticle(req, userID, role.Can(users.ManageContent))
 	if err != nil {
 		return nil, err
 	}
@@ -104,16 +167,16 @@ func (u *articlesUsecase) UpdateArticle(req *articles.ArticleCredential, userID
 	return jsonArticle, nil
 }
 
-func (u *articlesUsecase) DeleteArticle(slug string, userID int) error {
-	artcleID, err := u.articlesRepository.GetArticleIdBySlug(slug)
+func (u *articlesUsecase) DeleteArticle(slug string, userID int, role users.Role) error {
+	artcleID, err := u.articlesRepository.GetArticleIdBySlug(slug, userID)
 	if err != nil {
 		return err
 	}
-	return u.articlesRepository.DeleteArticle(artcleID, userID)
+	return u.articlesRepository.DeleteArticle(artcleID, userID, role.Can(users.ManageContent))
 }
 
 func (u *articlesUsecase) FavoriteArticle(slug string, userID int) (*articles.JSONArticle, error) {
-	articleID, err := u.articlesRepository.GetArticleIdBySlug(slug)
+	articleID, err := u.articlesRepository.GetArticleIdBySlug(slug, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -128,7 +191,7 @@ func (u *articlesUsecase) FavoriteArticle(slug string, userID int) (*articles.JS
 }
 
 func (u *articlesUsecase) UnfavoriteArticle(slug string, userID int) (*articles.JSONArticle, error) {
-	articleID, err := u.articlesRepository.GetArticleIdBySlug(slug)
+	articleID, err := u.articlesRepository.GetArticleIdBySlug(slug, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -145,3 +208,101 @@ func (u *articlesUsecase) UnfavoriteArticle(slug string, userID int) (*articles.
 func (u *articlesUsecase) GetTagsList() (*articles.TagList, error) {
 	return u.articlesRepository.GetTagsList()
 }
+
+func (u *articlesUsecase) PublishScheduledArticles(ctx context.Context) error {
+	count, err := u.articlesRepository.PublishScheduledArticles()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		log.Printf("published %d scheduled articles", count)
+	}
+	return nil
+}
+
+// revisionsArticleId finds the article for the revision endpoints, the
+// history is only shown to the author.
+func (u *articlesUsecase) revisionsArticleId(slug string, userId int) (int, error) {
+	articleId, err := u.articlesRepository.GetArticleIdBySlug(slug, userId)
+	if err != nil {
+		return 0, err
+	}
+	authorId, err := u.articlesRepository.GetArticleAuthorId(articleId)
+	if err != nil {
+		return 0, err
+	}
+	if authorId != userId {
+		return 0, fmt.Errorf("only the author can access the revisions")
+	}
+	return articleId, nil
+}
+
+func (u *articlesUsecase) GetRevisions(slug string, userId int) (*articles.JSONRevisions, error) {
+	articleId, err := u.revisionsArticleId(slug, userId)
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := u.articlesRepository.FindRevisions(articleId)
+	if err != nil {
+		return nil, err
+	}
+	return &articles.JSONRevisions{
+		Revisions: revisions,
+	}, nil
+}
+
+func (u *articlesUsecase) GetRevision(slug string, userId, revision int) (*articles.JSONRevision, error) {
+	articleId, err := u.revisionsArticleId(slug, userId)
+	if err != nil {
+		return nil, err
+	}
+	result, err := u.articlesRepository.FindRevision(articleId, revision)
+	if err != nil {
+		return nil, err
+	}
+	return &articles.JSONRevision{
+		Revision: result,
+	}, nil
+}
+
+func (u *articlesUsecase) GetRevisionDiff(slug string, userId, from, to int) (*articles.JSONRevisionDiff, error) {
+	articleId, err := u.revisionsArticleId(slug, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	older := new(articles.Revision)
+	if from > 0 {
+		if older, err = u.articlesRepository.FindRevision(articleId, from); err != nil {
+			return nil, err
+		}
+	}
+	newer, err := u.articlesRepository.FindRevision(articleId, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return &articles.JSONRevisionDiff{
+		Diff: &articles.RevisionDiff{
+			From:        from,
+			To:          to,
+			Title:       diff.Lines(older.Title, newer.Title),
+			Description: diff.Lines(older.Description, newer.Description),
+			Body:        diff.Lines(older.Body, newer.Body),
+		},
+	}, nil
+}
+
+func (u *articlesUsecase) RestoreRevision(slug string, userId, revision int) (*articles.JSONArticle, error) {
+	articleId, err := u.revisionsArticleId(slug, userId)
+	if err != nil {
+		return nil, err
+	}
+	article, err := u.articlesRepository.RestoreRevision(articleId, revision, userId)
+	if err != nil {
+		return nil, err
+	}
+	return &articles.JSONArticle{
+		Article: article,
+	}, nil
+}
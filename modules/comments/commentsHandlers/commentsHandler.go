@@ -9,6 +9,7 @@ import (
 	"github.com/NattpkJsw/real-world-api-go/modules/comments"
 	commentsusecases "github.com/NattpkJsw/real-world-api-go/modules/comments/commentsUsecases"
 	"github.com/NattpkJsw/real-world-api-go/modules/entities"
+	"github.com/NattpkJsw/real-world-api-go/modules/users"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -117,7 +118,8 @@ func (h *commentsHandler) DeleteComment(c *fiber.Ctx) error {
 		).Res()
 	}
 
-	if err := h.commentsUsecase.DeleteComment(commentID, userID); err != nil {
+	role, _ := c.Locals("userRole").(users.Role)
+	if err := h.commentsUsecase.DeleteComment(commentID, userID, role); err != nil {
 		return entities.NewResponse(c).Error(
 			fiber.ErrInternalServerError.Code,
 			string(deleteCommentsErr),
@@ -13,7 +13,7 @@ import (
 type ICommentsRepository interface {
 	FindComments(aritcleID, userID int) ([]*comments.Comment, error)
 	InsertComment(req *comments.CommentCredential) (*comments.Comment, error)
-	DeleteComment(commentID, userID int) error
+	DeleteComment(commentID, userID int, canModerate bool) error
 }
 
 type commentRepository struct {
@@ -140,13 +140,13 @@ func (r *commentRepository) InsertComment(req *comments.CommentCredential) (*com
 	return r.FindSingleComment(commentID, req.AuthorID)
 }
 
-func (r *commentRepository) DeleteComment(commentID, userID int) error {
+func (r *commentRepository) DeleteComment(commentID, userID int, canModerate bool) error {
 	query := `
 	DELETE
 	FROM "comments"
-	WHERE "id" = $1 AND "author_id" = $2`
+	WHERE "id" = $1 AND ("author_id" = $2 OR $3)`
 
-	result, err := r.db.ExecContext(context.Background(), query, commentID, userID)
+	result, err := r.db.ExecContext(context.Background(), query, commentID, userID, canModerate)
 	if err != nil {
 		return fmt.Errorf("delete comment failed: %v", err)
 	}
@@ -5,12 +5,13 @@ import (
 	articlesrepositories "github.com/NattpkJsw/real-world-api-go/modules/articles/articlesRepositories"
 	"github.com/NattpkJsw/real-world-api-go/modules/comments"
 	commentsrepositories "github.com/NattpkJsw/real-world-api-go/modules/comments/commentsRepositories"
+	"github.com/NattpkJsw/real-world-api-go/modules/users"
 )
 
 type ICommentUsecase interface {
 	FindComments(slug string, userID int) (*comments.JSONComment, error)
 	InsertComment(slug string, req *comments.CommentCredential) (*comments.JSONSingleComment, error)
-	DeleteComment(commentID, userID int) error
+	DeleteComment(commentID, userID int, role users.Role) error
 }
 
 type commentUsecase struct {
@@ -28,7 +29,7 @@ func CommentUsecase(cfg config.IConfig, commentRepository commentsrepositories.I
 }
 
 func (u *commentUsecase) FindComments(slug string, userID int) (*comments.JSONComment, error) {
-	articleID, err := u.articlesRepository.GetArticleIdBySlug(slug)
+	articleID, err := u.articlesRepository.GetArticleIdBySlug(slug, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -45,7 +46,7 @@ func (u *commentUsecase) FindComments(slug string, userID int) (*comments.JSONCo
 }
 
 func (u *commentUsecase) InsertComment(slug string, req *comments.CommentCredential) (*comments.JSONSingleComment, error) {
-	articleID, err := u.articlesRepository.GetArticleIdBySlug(slug)
+	articleID, err := u.articlesRepository.GetArticleIdBySlug(slug, req.AuthorID)
 	if err != nil {
 		return nil, err
 	}
@@ -63,7 +64,7 @@ func (u *commentUsecase) InsertComment(slug string, req *comments.CommentCredent
 
 }
 
-func (u *commentUsecase) DeleteComment(commentID, userID int) error {
-	return u.commentRepository.DeleteComment(commentID, userID)
+func (u *commentUsecase) DeleteComment(commentID, userID int, role users.Role) error {
+	return u.commentRepository.DeleteComment(commentID, userID, role.Can(users.ManageContent))
 
 }
@@ -1,6 +1,10 @@
 package entities
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+
 	"github.com/NattpkJsw/real-world-api-go/pkg/logger"
 	"github.com/gofiber/fiber/v2"
 )
@@ -8,6 +12,7 @@ import (
 type IResponse interface {
 	Success(code int, data any) IResponse
 	Error(code int, traceId, msg string) IResponse
+	Invalid(traceId string, err *ValidationError) IResponse
 	Res() error
 }
 
@@ -20,8 +25,43 @@ type Response struct {
 }
 
 type ErrorResponse struct {
-	TraceId string `json:"trace_id"`
-	Msg     string `json:"message"`
+	TraceId string              `json:"trace_id"`
+	Msg     string              `json:"message"`
+	Errors  map[string][]string `json:"errors,omitempty"`
+}
+
+// ValidationError lists the problems of each field of a request, e.g.
+// {"password": ["is too short (minimum is 8 characters)"]}.
+type ValidationError struct {
+	Fields map[string][]string
+}
+
+func NewValidationError() *ValidationError {
+	return &ValidationError{
+		Fields: make(map[string][]string),
+	}
+}
+
+func (e *ValidationError) Add(field string, problems ...string) {
+	e.Fields[field] = append(e.Fields[field], problems...)
+}
+
+func (e *ValidationError) HasErrors() bool {
+	return len(e.Fields) > 0
+}
+
+func (e *ValidationError) Error() string {
+	fields := make([]string, 0, len(e.Fields))
+	for field := range e.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	msgs := make([]string, 0, len(fields))
+	for _, field := range fields {
+		msgs = append(msgs, fmt.Sprintf("%s %s", field, strings.Join(e.Fields[field], ", ")))
+	}
+	return strings.Join(msgs, "; ")
 }
 
 func NewResponse(c *fiber.Ctx) IResponse {
@@ -46,6 +86,17 @@ func (r *Response) Error(code int, traceId, msg string) IResponse {
 	logger.InitLogger(r.Context, &r.ErrorRes).Print()
 	return r
 }
+func (r *Response) Invalid(traceId string, err *ValidationError) IResponse {
+	r.StatusCode = fiber.StatusUnprocessableEntity
+	r.ErrorRes = &ErrorResponse{
+		TraceId: traceId,
+		Msg:     err.Error(),
+		Errors:  err.Fields,
+	}
+	r.IsError = true
+	logger.InitLogger(r.Context, &r.ErrorRes).Print()
+	return r
+}
 func (r *Response) Res() error {
 	return r.Context.Status(r.StatusCode).JSON(func() any {
 		if r.IsError {
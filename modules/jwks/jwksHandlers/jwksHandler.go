@@ -0,0 +1,75 @@
+package jwkshandlers
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"sort"
+
+	"github.com/NattpkJsw/real-world-api-go/config"
+	"github.com/NattpkJsw/real-world-api-go/modules/entities"
+	"github.com/NattpkJsw/real-world-api-go/modules/jwks"
+	"github.com/gofiber/fiber/v2"
+)
+
+type IJwksHandler interface {
+	GetKeys(c *fiber.Ctx) error
+}
+
+type jwksHandler struct {
+	cfg config.IConfig
+}
+
+func JwksHandler(cfg config.IConfig) IJwksHandler {
+	return &jwksHandler{
+		cfg: cfg,
+	}
+}
+
+func (h *jwksHandler) GetKeys(c *fiber.Ctx) error {
+	verifyKeys := h.cfg.Jwt().VerifyKeys()
+	kids := make([]string, 0, len(verifyKeys))
+	for kid := range verifyKeys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	res := &jwks.KeySet{
+		Keys: make([]*jwks.Key, 0),
+	}
+	for _, kid := range kids {
+		if key := toJwk(kid, verifyKeys[kid]); key != nil {
+			res.Keys = append(res.Keys, key)
+		}
+	}
+
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return entities.NewResponse(c).Success(fiber.StatusOK, res).Res()
+}
+
+func toJwk(kid string, pub crypto.PublicKey) *jwks.Key {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return &jwks.Key{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return &jwks.Key{
+			Kty: "OKP",
+			Kid: kid,
+			Use: "sig",
+			Alg: "EdDSA",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(key),
+		}
+	default:
+		return nil
+	}
+}
@@ -5,4 +5,16 @@ type JwtLevel string
 const (
 	WriteLevel JwtLevel = "write"
 	ReadLevel  JwtLevel = "read"
+	// VerifiedLevel is write access that may also require a verified email,
+	// depending on the unverified account policy
+	VerifiedLevel JwtLevel = "verified"
 )
+
+// AuditLog is one write made by an admin impersonating a user
+type AuditLog struct {
+	ActorId int    `db:"actor_id"`
+	UserId  int    `db:"user_id"`
+	Action  string `db:"action"`
+	Status  int    `db:"status"`
+	Ip      string `db:"ip"`
+}
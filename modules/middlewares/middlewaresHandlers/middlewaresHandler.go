@@ -1,12 +1,14 @@
 package middlewareshandlers
 
 import (
+	"log"
 	"strings"
 
 	"github.com/NattpkJsw/real-world-api-go/config"
 	"github.com/NattpkJsw/real-world-api-go/modules/entities"
 	"github.com/NattpkJsw/real-world-api-go/modules/middlewares"
 	middlewaresUsecases "github.com/NattpkJsw/real-world-api-go/modules/middlewares/middlewaresUsecases"
+	"github.com/NattpkJsw/real-world-api-go/modules/users"
 	"github.com/NattpkJsw/real-world-api-go/pkg/auth"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -18,6 +20,9 @@ type middlewaresHandlersErrCode string
 const (
 	routerCheckErr middlewaresHandlersErrCode = "middleware-001"
 	jwtAuthErr     middlewaresHandlersErrCode = "middleware-002"
+	verifiedErr    middlewaresHandlersErrCode = "middleware-003"
+	roleErr        middlewaresHandlersErrCode = "middleware-004"
+	impersonateErr middlewaresHandlersErrCode = "middleware-005"
 )
 
 type IMiddlewaresHandler interface {
@@ -25,6 +30,9 @@ type IMiddlewaresHandler interface {
 	RouterCheck() fiber.Handler
 	Logger() fiber.Handler
 	JwtAuth(jwtLevel string) fiber.Handler
+	RequireRole(roles ...users.Role) fiber.Handler
+	RequirePermission(permission users.Permission) fiber.Handler
+	RejectImpersonation() fiber.Handler
 }
 type middlewaresHandler struct {
 	cfg                config.IConfig
@@ -70,9 +78,15 @@ func (h *middlewaresHandler) Logger() fiber.Handler {
 
 func (h *middlewaresHandler) JwtAuth(jwtLevel string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		if key := c.Get("X-Api-Key"); key != "" && c.Get("Authorization") == "" {
+			return h.apiKeyAuth(c, jwtLevel, key)
+		}
+
 		token := strings.TrimPrefix(c.Get("Authorization"), "Token ")
 		if jwtLevel == string(middlewares.ReadLevel) && token == "" {
 			c.Locals("userId", 0)
+			c.Locals("userRole", users.UserRole)
+			c.Locals("impersonatorId", 0)
 			return c.Next()
 		}
 		result, err := auth.ParseToken(h.cfg.Jwt(), token)
@@ -86,7 +100,7 @@ func (h *middlewaresHandler) JwtAuth(jwtLevel string) fiber.Handler {
 		}
 
 		claims := result.Claims
-		if !h.middlewaresUsecase.FindAccessToken(claims.Id, token) {
+		if result.Subject != "access-token" || !h.sessionActive(claims.Id, result.ID, token, jwtLevel) {
 			return entities.NewResponse(c).Error(
 				fiber.ErrUnauthorized.Code,
 				string(jwtAuthErr),
@@ -94,9 +108,178 @@ func (h *middlewaresHandler) JwtAuth(jwtLevel string) fiber.Handler {
 			).Res()
 		}
 
+		if h.requireVerified(jwtLevel) && !h.middlewaresUsecase.FindUserVerified(claims.Id) {
+			return entities.NewResponse(c).Error(
+				fiber.ErrForbidden.Code,
+				string(verifiedErr),
+				"email address is not verified",
+			).Res()
+		}
+
+		role := claims.Role
+		if !role.IsValid() {
+			role = users.UserRole
+		}
+
 		//Set UserId
 		c.Locals("userId", claims.Id)
+		c.Locals("userRole", role)
 		c.Locals("accessToken", token)
+		c.Locals("impersonatorId", claims.ImpersonatorId)
+		if claims.ImpersonatorId != 0 {
+			return h.audit(c, claims.ImpersonatorId, claims.Id)
+		}
+		return c.Next()
+	}
+}
+
+// audit records the writes made with an impersonation token once the
+// handler has answered, reads are not recorded.
+func (h *middlewaresHandler) audit(c *fiber.Ctx, actorId, userId int) error {
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return c.Next()
+	}
+
+	action := c.Method() + " " + c.Path()
+	err := c.Next()
+	if auditErr := h.middlewaresUsecase.InsertAuditLog(&middlewares.AuditLog{
+		ActorId: actorId,
+		UserId:  userId,
+		Action:  action,
+		Status:  c.Response().StatusCode(),
+		Ip:      c.IP(),
+	}); auditErr != nil {
+		log.Printf("audit %s by user %d failed: %v", action, actorId, auditErr)
+	}
+	return err
+}
+
+// RequireRole must be placed after JwtAuth.
+func (h *middlewaresHandler) RequireRole(roles ...users.Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role, _ := c.Locals("userRole").(users.Role)
+		for _, r := range roles {
+			if role == r {
+				return c.Next()
+			}
+		}
+		return entities.NewResponse(c).Error(
+			fiber.ErrForbidden.Code,
+			string(roleErr),
+			"no permission to access",
+		).Res()
+	}
+}
+
+// RequirePermission must be placed after JwtAuth.
+func (h *middlewaresHandler) RequirePermission(permission users.Permission) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role, _ := c.Locals("userRole").(users.Role)
+		if !role.Can(permission) {
+			return entities.NewResponse(c).Error(
+				fiber.ErrForbidden.Code,
+				string(roleErr),
+				"no permission to access",
+			).Res()
+		}
 		return c.Next()
 	}
 }
+
+// RejectImpersonation must be placed after JwtAuth. It guards the account
+// settings an admin acting as the user has no business changing.
+func (h *middlewaresHandler) RejectImpersonation() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if impersonatorId, _ := c.Locals("impersonatorId").(int); impersonatorId != 0 {
+			return entities.NewResponse(c).Error(
+				fiber.ErrForbidden.Code,
+				string(impersonateErr),
+				"not allowed while impersonating",
+			).Res()
+		}
+		return c.Next()
+	}
+}
+
+// sessionActive applies the auth mode: "stateful" looks the token up in the
+// oauth table, "stateless" only checks the denylist and "hybrid" checks the
+// denylist and also looks the token up for every level above read.
+func (h *middlewaresHandler) sessionActive(userId int, jti, token, jwtLevel string) bool {
+	mode := h.cfg.Jwt().AuthMode()
+	if mode == "stateful" {
+		return h.middlewaresUsecase.FindAccessToken(userId, token)
+	}
+
+	// Tokens without an id cannot be revoked, they are not trusted alone
+	if jti == "" || h.middlewaresUsecase.IsTokenRevoked(jti) {
+		return false
+	}
+	if mode == "hybrid" && jwtLevel != string(middlewares.ReadLevel) {
+		return h.middlewaresUsecase.FindAccessToken(userId, token)
+	}
+	return true
+}
+
+// requireVerified applies the unverified account policy: "content" blocks
+// the verified level only, "write" blocks every write level.
+func (h *middlewaresHandler) requireVerified(jwtLevel string) bool {
+	switch h.cfg.Users().UnverifiedPolicy() {
+	case "content":
+		return jwtLevel == string(middlewares.VerifiedLevel)
+	case "write":
+		return jwtLevel != string(middlewares.ReadLevel)
+	default:
+		return false
+	}
+}
+
+func (h *middlewaresHandler) apiKeyAuth(c *fiber.Ctx, jwtLevel, key string) error {
+	result, err := auth.ParseApiKey(h.cfg.Jwt(), key)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrUnauthorized.Code,
+			string(jwtAuthErr),
+			err.Error(),
+		).Res()
+	}
+
+	claims := result.Claims
+	if claims == nil || result.Subject != "api-key" {
+		return entities.NewResponse(c).Error(
+			fiber.ErrUnauthorized.Code,
+			string(jwtAuthErr),
+			"api key is invalid",
+		).Res()
+	}
+	scope, err := h.middlewaresUsecase.FindApiKeyScope(claims.Id, result.ID)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrUnauthorized.Code,
+			string(jwtAuthErr),
+			"no permission to access",
+		).Res()
+	}
+	if jwtLevel != string(middlewares.ReadLevel) && scope != string(middlewares.WriteLevel) {
+		return entities.NewResponse(c).Error(
+			fiber.ErrForbidden.Code,
+			string(jwtAuthErr),
+			"api key scope does not allow write access",
+		).Res()
+	}
+	if h.requireVerified(jwtLevel) && !h.middlewaresUsecase.FindUserVerified(claims.Id) {
+		return entities.NewResponse(c).Error(
+			fiber.ErrForbidden.Code,
+			string(verifiedErr),
+			"email address is not verified",
+		).Res()
+	}
+
+	// API keys never carry elevated roles
+	c.Locals("userId", claims.Id)
+	c.Locals("userRole", users.UserRole)
+	c.Locals("accessToken", "")
+	c.Locals("impersonatorId", 0)
+	c.Locals("apiKeyId", result.ID)
+	return c.Next()
+}
@@ -1,11 +1,18 @@
 package middlewaresrepositories
 
 import (
+	"fmt"
+
+	"github.com/NattpkJsw/real-world-api-go/modules/middlewares"
+	"github.com/NattpkJsw/real-world-api-go/pkg/utils"
 	"github.com/jmoiron/sqlx"
 )
 
 type IMiddlewaresRepository interface {
 	FindAccessToken(userId int, accessToken string) bool
+	FindApiKeyScope(userId int, apiKeyId string) (string, error)
+	FindUserVerified(userId int) bool
+	InsertAuditLog(req *middlewares.AuditLog) error
 }
 
 type middlewaresRepository struct {
@@ -18,19 +25,70 @@ func MiddlewaresRepository(db *sqlx.DB) IMiddlewaresRepository {
 	}
 }
 
+// FindAccessToken also stamps the session as used, so the session list can
+// show when each login was last active.
 func (r *middlewaresRepository) FindAccessToken(userId int, accessToken string) bool {
+	query := `
+	UPDATE "oauth" SET
+		"last_used_at" = CURRENT_TIMESTAMP
+	WHERE "user_id" = $1 AND "access_token_hash" = $2
+	RETURNING "id";`
+
+	var id string
+	if err := r.db.Get(&id, query, userId, utils.HashToken(accessToken)); err != nil {
+		return false
+	}
+	return id != ""
+}
+
+// FindApiKeyScope ignores the keys of an account waiting to be deleted, they
+// work again if the user logs in and so cancels the deletion.
+func (r *middlewaresRepository) FindApiKeyScope(userId int, apiKeyId string) (string, error) {
+	query := `
+	UPDATE "api_keys" SET
+		"last_used_at" = CURRENT_TIMESTAMP
+	WHERE "id" = $1 AND "user_id" = $2
+	AND NOT EXISTS (
+		SELECT 1
+		FROM "users"
+		WHERE "id" = $2 AND "deletion_scheduled_at" IS NOT NULL
+	)
+	RETURNING "scope";`
+
+	var scope string
+	if err := r.db.Get(&scope, query, apiKeyId, userId); err != nil {
+		return "", fmt.Errorf("api key not found")
+	}
+	return scope, nil
+}
+
+func (r *middlewaresRepository) FindUserVerified(userId int) bool {
 	query := `
 	SELECT
-		(CASE WHEN COUNT(*) = 1 THEN TRUE ELSE FALSE END)
-	FROM "oauth"
-	WHERE "user_id" = $1 AND "access_token" = $2;`
+		("verified_at" IS NOT NULL)
+	FROM "users"
+	WHERE "id" = $1;`
 
-	var check bool
-	if err := r.db.Get(&check, query, userId, accessToken); err != nil {
+	var verified bool
+	if err := r.db.Get(&verified, query, userId); err != nil {
 		return false
 	}
-	if check {
-		return true
+	return verified
+}
+
+func (r *middlewaresRepository) InsertAuditLog(req *middlewares.AuditLog) error {
+	query := `
+	INSERT INTO "audit_logs" (
+		"actor_id",
+		"user_id",
+		"action",
+		"status",
+		"ip"
+	)
+	VALUES ($1, $2, $3, $4, $5);`
+
+	if _, err := r.db.Exec(query, req.ActorId, req.UserId, req.Action, req.Status, req.Ip); err != nil {
+		return fmt.Errorf("insert audit log failed: %v", err)
 	}
-	return false
+	return nil
 }
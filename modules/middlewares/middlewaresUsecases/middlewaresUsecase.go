@@ -1,21 +1,60 @@
 package middlewaresusecases
 
-import middlewaresrepositories "github.com/NattpkJsw/real-world-api-go/modules/middlewares/middlewaresRepositories"
+import (
+	"github.com/NattpkJsw/real-world-api-go/modules/middlewares"
+	middlewaresrepositories "github.com/NattpkJsw/real-world-api-go/modules/middlewares/middlewaresRepositories"
+	"github.com/NattpkJsw/real-world-api-go/pkg/tokencache"
+	"github.com/NattpkJsw/real-world-api-go/pkg/utils"
+)
 
 type IMiddlewaresUsecase interface {
 	FindAccessToken(userId int, accessToken string) bool
+	IsTokenRevoked(jti string) bool
+	FindApiKeyScope(userId int, apiKeyId string) (string, error)
+	FindUserVerified(userId int) bool
+	InsertAuditLog(req *middlewares.AuditLog) error
 }
 
 type middlewaresUsecase struct {
 	middlewaresRepository middlewaresrepositories.IMiddlewaresRepository
+	tokenCache            tokencache.ICache
 }
 
-func MiddlewaresUsecase(middlewaresRepository middlewaresrepositories.IMiddlewaresRepository) IMiddlewaresUsecase {
+func MiddlewaresUsecase(middlewaresRepository middlewaresrepositories.IMiddlewaresRepository, tokenCache tokencache.ICache) IMiddlewaresUsecase {
 	return &middlewaresUsecase{
 		middlewaresRepository: middlewaresRepository,
+		tokenCache:            tokenCache,
 	}
 }
 
+// FindAccessToken answers from the token cache when it can. Cache hits skip
+// the last_used_at stamp, so it is only as fresh as the cache ttl.
 func (u *middlewaresUsecase) FindAccessToken(userId int, accessToken string) bool {
-	return u.middlewaresRepository.FindAccessToken(userId, accessToken)
+	tokenHash := utils.HashToken(accessToken)
+	if cachedId, ok := u.tokenCache.Get(tokenHash); ok && cachedId == userId {
+		return true
+	}
+
+	version := u.tokenCache.Version()
+	if !u.middlewaresRepository.FindAccessToken(userId, accessToken) {
+		return false
+	}
+	u.tokenCache.Set(tokenHash, userId, version)
+	return true
+}
+
+func (u *middlewaresUsecase) IsTokenRevoked(jti string) bool {
+	return u.tokenCache.IsRevoked(jti)
+}
+
+func (u *middlewaresUsecase) FindApiKeyScope(userId int, apiKeyId string) (string, error) {
+	return u.middlewaresRepository.FindApiKeyScope(userId, apiKeyId)
+}
+
+func (u *middlewaresUsecase) FindUserVerified(userId int) bool {
+	return u.middlewaresRepository.FindUserVerified(userId)
+}
+
+func (u *middlewaresUsecase) InsertAuditLog(req *middlewares.AuditLog) error {
+	return u.middlewaresRepository.InsertAuditLog(req)
 }
@@ -37,7 +37,7 @@ func (m *moduleFactory) ArticlesModule() IArticleModule {
 func (a *articleModule) Init() {
 	router := a.router.Group("/articles")
 
-	router.Post("/", a.middle.JwtAuth(string(middlewares.WriteLevel)), a.handler.CreateArticle)
+	router.Post("/", a.middle.JwtAuth(string(middlewares.VerifiedLevel)), a.handler.CreateArticle)
 	router.Put("/:slug", a.middle.JwtAuth(string(middlewares.WriteLevel)), a.handler.UpdateArticle)
 	router.Get("/:slug", a.middle.JwtAuth(string(middlewares.ReadLevel)), a.handler.GetSingleArticle)
 	router.Get("/", a.middle.JwtAuth(string(middlewares.ReadLevel)), a.handler.GetArticlesList)
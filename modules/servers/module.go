@@ -1,12 +1,15 @@
 package servers
 
 import (
+	"time"
+
 	articleshandlers "github.com/NattpkJsw/real-world-api-go/modules/articles/articlesHandlers"
 	articlesrepositories "github.com/NattpkJsw/real-world-api-go/modules/articles/articlesRepositories"
 	articlesusecases "github.com/NattpkJsw/real-world-api-go/modules/articles/articlesUsecases"
 	commentshandlers "github.com/NattpkJsw/real-world-api-go/modules/comments/commentsHandlers"
 	commentsrepositories "github.com/NattpkJsw/real-world-api-go/modules/comments/commentsRepositories"
 	commentsusecases "github.com/NattpkJsw/real-world-api-go/modules/comments/commentsUsecases"
+	jwkshandlers "github.com/NattpkJsw/real-world-api-go/modules/jwks/jwksHandlers"
 	"github.com/NattpkJsw/real-world-api-go/modules/middlewares"
 	middlewareshandlers "github.com/NattpkJsw/real-world-api-go/modules/middlewares/middlewaresHandlers"
 	middlewaresrepositories "github.com/NattpkJsw/real-world-api-go/modules/middlewares/middlewaresRepositories"
@@ -15,9 +18,12 @@ import (
 	profileshandlers "github.com/NattpkJsw/real-world-api-go/modules/profiles/profilesHandlers"
 	profilesrepositories "github.com/NattpkJsw/real-world-api-go/modules/profiles/profilesRepositories"
 	profilesusecases "github.com/NattpkJsw/real-world-api-go/modules/profiles/profilesUsecases"
+	"github.com/NattpkJsw/real-world-api-go/modules/users"
 	usershandlers "github.com/NattpkJsw/real-world-api-go/modules/users/usersHandlers"
 	usersrepositories "github.com/NattpkJsw/real-world-api-go/modules/users/usersRepositories"
 	usersusecases "github.com/NattpkJsw/real-world-api-go/modules/users/usersUsecases"
+	"github.com/NattpkJsw/real-world-api-go/pkg/mailer"
+	"github.com/NattpkJsw/real-world-api-go/pkg/scheduler"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -30,6 +36,11 @@ type IModulefactory interface {
 	CommentModule()
 	TagModule()
 	ArticlesModule() IArticleModule
+	AdminModule()
+	InvitesModule()
+	JwksModule()
+	UsersJobs(jobs scheduler.IScheduler)
+	ArticlesJobs(jobs scheduler.IScheduler)
 }
 
 type moduleFactory struct {
@@ -48,7 +59,7 @@ func InitModule(r fiber.Router, s *server, m middlewareshandlers.IMiddlewaresHan
 
 func InitMiddlewares(s *server) middlewareshandlers.IMiddlewaresHandler {
 	repository := middlewaresrepositories.MiddlewaresRepository(s.db)
-	usecase := middlewaresusecases.MiddlewaresUsecase(repository)
+	usecase := middlewaresusecases.MiddlewaresUsecase(repository, s.tokenCache)
 	return middlewareshandlers.MiddlewaresHandler(s.cfg, usecase)
 }
 
@@ -58,25 +69,61 @@ func (m *moduleFactory) MonitorModule() {
 	m.router.Get("/", handler.HealthCheck)
 }
 
+func (m *moduleFactory) JwksModule() {
+	handler := jwkshandlers.JwksHandler(m.server.cfg)
+
+	m.router.Get("/jwks.json", handler.GetKeys)
+}
+
 func (m *moduleFactory) UsersModule() {
 	repository := usersrepositories.UsersRepository(m.server.db)
-	usecase := usersusecases.UsersUsecase(m.server.cfg, repository)
+	usecase := usersusecases.UsersUsecase(m.server.cfg, repository, mailer.NewMailer(m.server.cfg.Mail()), m.server.loginStore, m.server.tokenCache, m.server.oidcClients, m.server.passwords, m.server.notifier)
 	handler := usershandlers.UsersHandler(m.server.cfg, usecase)
 
 	router := m.router.Group("/users")
 	router.Post("/", handler.SignUp)
 	router.Post("/login", handler.LogIn)
+	router.Post("/login/2fa", handler.LogInTwoFactor)
+	router.Post("/login/magic", handler.SendMagicLink)
+	router.Post("/login/magic/verify", handler.LogInMagicLink)
+	router.Post("/refresh", handler.RefreshPassport)
+	router.Post("/password/forgot", handler.ForgotPassword)
+	router.Post("/password/reset", handler.ResetPassword)
+	router.Post("/verify", handler.VerifyEmail)
+	router.Post("/email/confirm", handler.ConfirmEmailChange)
+	router.Get("/oidc/:provider", handler.OidcAuthorize)
+	router.Post("/oidc/:provider/callback", handler.OidcLogIn)
 	router.Post("/logout", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.LogOut)
 }
 
 func (m *moduleFactory) UserModule() {
 	repository := usersrepositories.UsersRepository(m.server.db)
-	usecase := usersusecases.UsersUsecase(m.server.cfg, repository)
+	usecase := usersusecases.UsersUsecase(m.server.cfg, repository, mailer.NewMailer(m.server.cfg.Mail()), m.server.loginStore, m.server.tokenCache, m.server.oidcClients, m.server.passwords, m.server.notifier)
 	handler := usershandlers.UsersHandler(m.server.cfg, usecase)
 
 	router := m.router.Group("/user")
 	router.Get("/", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.GetUser)
 	router.Put("/", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.UpdateUser)
+	router.Delete("/", m.middle.JwtAuth(string(middlewares.WriteLevel)), m.middle.RejectImpersonation(), handler.DeleteUser)
+	router.Get("/export", m.middle.JwtAuth(string(middlewares.WriteLevel)), m.middle.RejectImpersonation(), handler.ExportUser)
+	router.Post("/password", m.middle.JwtAuth(string(middlewares.WriteLevel)), m.middle.RejectImpersonation(), handler.ChangePassword)
+	router.Post("/email", m.middle.JwtAuth(string(middlewares.WriteLevel)), m.middle.RejectImpersonation(), handler.ChangeEmail)
+	// Read level keeps resending possible under the "write" unverified policy
+	router.Post("/verify/resend", m.middle.JwtAuth(string(middlewares.ReadLevel)), handler.ResendVerification)
+
+	router.Get("/sessions", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.GetSessions)
+	router.Delete("/sessions/:id", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.DeleteSession)
+	router.Delete("/sessions", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.DeleteSessions)
+	router.Get("/login-history", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.GetLoginHistory)
+
+	router.Get("/api-keys", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.GetApiKeys)
+	router.Post("/api-keys", m.middle.JwtAuth(string(middlewares.WriteLevel)), m.middle.RejectImpersonation(), handler.CreateApiKey)
+	router.Delete("/api-keys/:id", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.DeleteApiKey)
+
+	router.Post("/2fa", m.middle.JwtAuth(string(middlewares.WriteLevel)), m.middle.RejectImpersonation(), handler.SetupTwoFactor)
+	router.Post("/2fa/confirm", m.middle.JwtAuth(string(middlewares.WriteLevel)), m.middle.RejectImpersonation(), handler.ConfirmTwoFactor)
+	router.Post("/2fa/recovery-codes", m.middle.JwtAuth(string(middlewares.WriteLevel)), m.middle.RejectImpersonation(), handler.RegenerateRecoveryCodes)
+	router.Delete("/2fa", m.middle.JwtAuth(string(middlewares.WriteLevel)), m.middle.RejectImpersonation(), handler.DisableTwoFactor)
 }
 
 func (m *moduleFactory) ProfileModule() {
@@ -98,14 +145,22 @@ func (m *moduleFactory) ArticleModule() {
 	router := m.router.Group("/articles")
 	router.Get("/", m.middle.JwtAuth(string(middlewares.ReadLevel)), handler.GetArticlesList)
 	router.Get("/feed/", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.GetArticlesFeed)
+	router.Get("/search", m.middle.JwtAuth(string(middlewares.ReadLevel)), handler.SearchArticles)
 	router.Get("/:slug", m.middle.JwtAuth(string(middlewares.ReadLevel)), handler.GetSingleArticle)
-	router.Post("/", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.CreateArticle)
+	router.Post("/", m.middle.JwtAuth(string(middlewares.VerifiedLevel)), handler.CreateArticle)
 	router.Put("/:slug", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.UpdateArticle)
 	router.Delete("/:slug", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.DeleteArticle)
 
 	router.Post("/:slug/favorite", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.FavoriteArticle)
 	router.Delete("/:slug/favorite", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.UnfavoriteArticle)
 
+	router.Get("/:slug/revisions", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.GetRevisions)
+	router.Get("/:slug/revisions/:n", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.GetRevision)
+	router.Get("/:slug/revisions/:n/diff", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.GetRevisionDiff)
+	router.Post("/:slug/revisions/:n/restore", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.RestoreRevision)
+
+	// Drafts are listed with the user's own resources
+	m.router.Get("/user/drafts", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.GetDrafts)
 }
 
 func (m *moduleFactory) CommentModule() {
@@ -116,7 +171,7 @@ func (m *moduleFactory) CommentModule() {
 
 	router := m.router.Group("/articles/:slug")
 	router.Get("/comments", m.middle.JwtAuth(string(middlewares.ReadLevel)), handler.FindComments)
-	router.Post("/comments", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.InsertComment)
+	router.Post("/comments", m.middle.JwtAuth(string(middlewares.VerifiedLevel)), handler.InsertComment)
 	router.Delete("/comments/:id", m.middle.JwtAuth(string(middlewares.WriteLevel)), handler.DeleteComment)
 
 }
@@ -128,3 +183,38 @@ func (m *moduleFactory) TagModule() {
 
 	m.router.Get("/tags", handler.GetTagsList)
 }
+
+func (m *moduleFactory) AdminModule() {
+	repository := usersrepositories.UsersRepository(m.server.db)
+	usecase := usersusecases.UsersUsecase(m.server.cfg, repository, mailer.NewMailer(m.server.cfg.Mail()), m.server.loginStore, m.server.tokenCache, m.server.oidcClients, m.server.passwords, m.server.notifier)
+	handler := usershandlers.UsersHandler(m.server.cfg, usecase)
+
+	router := m.router.Group("/admin", m.middle.JwtAuth(string(middlewares.WriteLevel)), m.middle.RequirePermission(users.ManageUsers))
+	router.Put("/users/:username/role", handler.UpdateUserRole)
+	router.Post("/impersonate/:username", m.middle.RejectImpersonation(), m.middle.RequirePermission(users.ImpersonateUsers), handler.Impersonate)
+}
+
+func (m *moduleFactory) InvitesModule() {
+	repository := usersrepositories.UsersRepository(m.server.db)
+	usecase := usersusecases.UsersUsecase(m.server.cfg, repository, mailer.NewMailer(m.server.cfg.Mail()), m.server.loginStore, m.server.tokenCache, m.server.oidcClients, m.server.passwords, m.server.notifier)
+	handler := usershandlers.UsersHandler(m.server.cfg, usecase)
+
+	router := m.router.Group("/invites", m.middle.JwtAuth(string(middlewares.WriteLevel)))
+	router.Get("/", handler.GetInvites)
+	router.Post("/", handler.CreateInvite)
+	router.Delete("/:id", handler.DeleteInvite)
+}
+
+func (m *moduleFactory) UsersJobs(jobs scheduler.IScheduler) {
+	repository := usersrepositories.UsersRepository(m.server.db)
+	usecase := usersusecases.UsersUsecase(m.server.cfg, repository, mailer.NewMailer(m.server.cfg.Mail()), m.server.loginStore, m.server.tokenCache, m.server.oidcClients, m.server.passwords, m.server.notifier)
+
+	jobs.Every("purge deleted users", time.Hour, usecase.PurgeDeletedUsers)
+}
+
+func (m *moduleFactory) ArticlesJobs(jobs scheduler.IScheduler) {
+	repository := articlesrepositories.ArticlesRepository(m.server.db)
+	usecase := articlesusecases.ArticlesUsecase(m.server.cfg, repository)
+
+	jobs.Every("publish scheduled articles", time.Minute, usecase.PublishScheduledArticles)
+}
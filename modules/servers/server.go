@@ -1,12 +1,20 @@
 package servers
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"os"
 	"os/signal"
 
 	"github.com/NattpkJsw/real-world-api-go/config"
+	"github.com/NattpkJsw/real-world-api-go/pkg/mailer"
+	"github.com/NattpkJsw/real-world-api-go/pkg/notifier"
+	"github.com/NattpkJsw/real-world-api-go/pkg/oidc"
+	"github.com/NattpkJsw/real-world-api-go/pkg/passwords"
+	"github.com/NattpkJsw/real-world-api-go/pkg/scheduler"
+	"github.com/NattpkJsw/real-world-api-go/pkg/throttle"
+	"github.com/NattpkJsw/real-world-api-go/pkg/tokencache"
 	"github.com/gofiber/fiber/v2"
 	"github.com/jmoiron/sqlx"
 )
@@ -17,15 +25,63 @@ type IServer interface {
 }
 
 type server struct {
-	app *fiber.App
-	db  *sqlx.DB
-	cfg config.IConfig
+	app         *fiber.App
+	db          *sqlx.DB
+	cfg         config.IConfig
+	loginStore  throttle.IStore
+	tokenCache  tokencache.ICache
+	oidcClients map[string]oidc.IClient
+	passwords   passwords.IChecker
+	notifier    notifier.INotifier
 }
 
 func NewServer(cfg config.IConfig, db *sqlx.DB) IServer {
 	return &server{
 		cfg: cfg,
 		db:  db,
+		loginStore: func() throttle.IStore {
+			if cfg.Users().LoginThrottleStore() == "postgres" {
+				return throttle.PostgresStore(db)
+			}
+			return throttle.MemoryStore()
+		}(),
+		tokenCache: tokencache.NewCache(cfg.Jwt().TokenCacheSize(), cfg.Jwt().TokenCacheTtl()),
+		oidcClients: func() map[string]oidc.IClient {
+			clients := make(map[string]oidc.IClient)
+			for _, p := range cfg.Oidc().Providers() {
+				clients[p.Name()] = oidc.NewClient(&oidc.Provider{
+					Name:         p.Name(),
+					Issuer:       p.Issuer(),
+					ClientId:     p.ClientId(),
+					ClientSecret: p.ClientSecret(),
+					RedirectUrl:  p.RedirectUrl(),
+					Scopes:       p.Scopes(),
+				}, nil)
+			}
+			return clients
+		}(),
+		passwords: func() passwords.IChecker {
+			var breached passwords.IBreachedList
+			if cfg.Users().PasswordBreachedFile() != "" {
+				list, err := passwords.OpenBreachedList(cfg.Users().PasswordBreachedFile())
+				if err != nil {
+					log.Fatalf("load password policy failed: %v", err)
+				}
+				breached = list
+			}
+			policy := &passwords.Policy{
+				MinLength: cfg.Users().PasswordMinLength(),
+			}
+			for _, class := range cfg.Users().PasswordClasses() {
+				policy.Require = append(policy.Require, passwords.Class(class))
+			}
+			return passwords.NewChecker(policy, breached)
+		}(),
+		notifier: notifier.NewNotifier(
+			cfg.Users().NotifierDriver(),
+			cfg.Users().NotifierWebhookUrl(),
+			mailer.NewMailer(cfg.Mail()),
+		),
 		app: fiber.New(fiber.Config{
 			AppName:      cfg.App().Name(),
 			BodyLimit:    cfg.App().BodyLimit(),
@@ -54,9 +110,27 @@ func (s *server) Start() {
 	modules.CommentModule()
 	modules.TagModule()
 	modules.UserModule()
+	modules.AdminModule()
+	modules.InvitesModule()
+
+	wellKnown := InitModule(s.app.Group("/.well-known"), s, middlewares)
+	wellKnown.JwksModule()
 
 	s.app.Use(middlewares.RouterCheck())
 
+	// Token cache and denylist, kept up to date by the revocation listener
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if s.cfg.Jwt().TokenCacheSize() > 0 || s.cfg.Jwt().AuthMode() != "stateful" {
+		go tokencache.Listen(ctx, s.db, s.tokenCache)
+	}
+
+	// Background jobs
+	jobs := scheduler.NewScheduler()
+	modules.UsersJobs(jobs)
+	modules.ArticlesJobs(jobs)
+	jobs.Start(ctx)
+
 	// Graceful Shutdown
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
@@ -3,16 +3,19 @@ package users
 import (
 	"fmt"
 	"regexp"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
-	Id       int     `db:"id" json:"id"`
-	Email    string  `db:"email" json:"email"`
-	Username string  `db:"username" json:"username"`
-	Image    *string `db:"image" json:"image"`
-	Bio      *string `db:"bio" json:"bio"`
+	Id         int        `db:"id" json:"id"`
+	Email      string     `db:"email" json:"email"`
+	Username   string     `db:"username" json:"username"`
+	Image      *string    `db:"image" json:"image"`
+	Bio        *string    `db:"bio" json:"bio"`
+	VerifiedAt *time.Time `db:"verified_at" json:"-"`
+	Role       Role       `db:"role" json:"-"`
 }
 
 type Profile struct {
@@ -27,9 +30,10 @@ type UserProfile struct {
 }
 
 type UserRegisterReq struct {
-	Username string `db:"username" json:"username"`
-	Email    string `db:"email" json:"email"`
-	Password string `db:"password" json:"password"`
+	Username   string `db:"username" json:"username"`
+	Email      string `db:"email" json:"email"`
+	Password   string `db:"password" json:"password"`
+	InviteCode string `db:"-" json:"inviteCode"`
 }
 
 type RegisterReq struct {
@@ -42,17 +46,28 @@ type ResponsePassport struct {
 
 type UserPassport struct {
 	// Id       int     `db:"id" json:"id"`
-	Email    string  `db:"email" json:"email"`
-	Username string  `db:"username" json:"username"`
-	Image    *string `db:"image" json:"image"`
-	Bio      *string `db:"bio" json:"bio"`
-	Token    string  `db:"access_token" json:"token"`
+	Email        string  `db:"email" json:"email"`
+	Username     string  `db:"username" json:"username"`
+	Image        *string `db:"image" json:"image"`
+	Bio          *string `db:"bio" json:"bio"`
+	Token        string  `db:"access_token" json:"token"`
+	RefreshToken string  `db:"refresh_token" json:"refreshToken,omitempty"`
 }
 
 type UserToken struct {
-	Id          string `db:"id" json:"id"`
-	User_Id     int    `db:"user_id" json:"user_id"`
-	AccessToken string `db:"access_token" json:"access_token"`
+	Id              string    `db:"id" json:"id"`
+	User_Id         int       `db:"user_id" json:"user_id"`
+	AccessToken     string    `db:"access_token" json:"access_token"`
+	AccessJti       string    `db:"access_jti" json:"-"`
+	AccessExpiresAt time.Time `db:"access_expires_at" json:"-"`
+	RefreshToken    string    `db:"refresh_token" json:"refresh_token"`
+	Ip              string    `db:"ip" json:"ip"`
+	UserAgent       string    `db:"user_agent" json:"user_agent"`
+}
+
+type UserDevice struct {
+	Ip        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
 }
 
 type UserCredential struct {
@@ -69,26 +84,396 @@ type UserCredentialInput struct {
 }
 
 type UserCredentialCheck struct {
-	Id          int     `json:"id" db:"id"`
-	Email       string  `json:"email" db:"email"`
-	Password    string  `json:"password" db:"password"`
-	Username    string  `json:"username" db:"username"`
-	Image       *string `json:"image" db:"image"`
-	Bio         *string `json:"bio" db:"bio"`
-	AccessToken string  `json:"access_token"`
+	Id          int        `json:"id" db:"id"`
+	Email       string     `json:"email" db:"email"`
+	Password    string     `json:"password" db:"password"`
+	Username    string     `json:"username" db:"username"`
+	Image       *string    `json:"image" db:"image"`
+	Bio         *string    `json:"bio" db:"bio"`
+	Role        Role       `json:"-" db:"role"`
+	TotpEnabled bool       `json:"-" db:"totp_enabled"`
+	VerifiedAt  *time.Time `json:"-" db:"verified_at"`
+	AccessToken string     `json:"access_token"`
 }
 
 type UserClaims struct {
-	Id int `db:"id" json:"id"`
+	Id   int  `db:"id" json:"id"`
+	Role Role `db:"role" json:"role"`
+	// ImpersonatorId is the admin acting as the user, zero on the user's own
+	// tokens
+	ImpersonatorId int `db:"-" json:"impersonatorId,omitempty"`
+}
+
+type Role string
+
+const (
+	UserRole      Role = "user"
+	ModeratorRole Role = "moderator"
+	AdminRole     Role = "admin"
+)
+
+type Permission string
+
+const (
+	// ManageContent allows editing and deleting any article or comment
+	ManageContent Permission = "content:manage"
+	// ManageUsers allows changing the role of other users
+	ManageUsers Permission = "users:manage"
+	// ManageInvites allows minting invite codes and revoking anyone's invite
+	ManageInvites Permission = "invites:manage"
+	// ImpersonateUsers allows signing in as another user, every write made
+	// that way is audited
+	ImpersonateUsers Permission = "users:impersonate"
+)
+
+var rolePermissions = map[Role][]Permission{
+	UserRole:      {},
+	ModeratorRole: {ManageContent},
+	AdminRole:     {ManageContent, ManageUsers, ManageInvites, ImpersonateUsers},
+}
+
+func (r Role) IsValid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+func (r Role) Can(permission Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+type UserRoleReq struct {
+	User struct {
+		Role Role `json:"role" form:"role"`
+	} `json:"user"`
 }
 
 type OauthToken struct {
 	AccessToken string `json:"access_token" form:"access_token"`
 }
 
+type OauthRefreshToken struct {
+	RefreshToken string `json:"refresh_token" form:"refresh_token"`
+}
+
 type Oauth struct {
 	Id     string `db:"id" json:"id"`
 	UserId int    `db:"user_id" json:"user_id"`
+	Role   Role   `db:"role" json:"role"`
+
+	// ImpersonatorId is the admin behind an impersonation session, 0 otherwise
+	ImpersonatorId  int        `db:"impersonator_id" json:"-"`
+	AccessExpiresAt *time.Time `db:"access_expires_at" json:"-"`
+}
+
+type Session struct {
+	Id         string  `db:"id" json:"id"`
+	Ip         *string `db:"ip" json:"ip"`
+	UserAgent  *string `db:"user_agent" json:"userAgent"`
+	CreatedAt  string  `db:"createdat" json:"createdAt"`
+	LastUsedAt *string `db:"last_used_at" json:"lastUsedAt"`
+	Current    bool    `db:"current" json:"current"`
+}
+
+type JSONSessions struct {
+	Sessions []*Session `json:"sessions"`
+}
+
+type LoginOutcome string
+
+const (
+	LoginSucceeded LoginOutcome = "success"
+	LoginFailed    LoginOutcome = "failure"
+)
+
+type LoginEvent struct {
+	Id                int          `db:"id" json:"id"`
+	UserId            int          `db:"user_id" json:"-"`
+	Ip                *string      `db:"ip" json:"ip"`
+	UserAgent         *string      `db:"user_agent" json:"userAgent"`
+	DeviceFingerprint string       `db:"device_fingerprint" json:"-"`
+	Outcome           LoginOutcome `db:"outcome" json:"outcome"`
+	FailureReason     *string      `db:"failure_reason" json:"failureReason"`
+	CreatedAt         string       `db:"createdat" json:"createdAt"`
+}
+
+type JSONLoginHistory struct {
+	LoginHistory []*LoginEvent `json:"loginHistory"`
+}
+
+type ApiKey struct {
+	Id         string  `db:"id" json:"id"`
+	Name       string  `db:"name" json:"name"`
+	Scope      string  `db:"scope" json:"scope"`
+	Key        string  `db:"-" json:"key,omitempty"`
+	CreatedAt  string  `db:"createdat" json:"createdAt"`
+	LastUsedAt *string `db:"last_used_at" json:"lastUsedAt"`
+}
+
+type ApiKeyReq struct {
+	UserId int    `json:"-"`
+	Name   string `json:"name"`
+	Scope  string `json:"scope"`
+}
+
+type JSONApiKeyReq struct {
+	ApiKey *ApiKeyReq `json:"apiKey"`
+}
+
+type JSONApiKey struct {
+	ApiKey *ApiKey `json:"apiKey"`
+}
+
+type JSONApiKeys struct {
+	ApiKeys []*ApiKey `json:"apiKeys"`
+}
+
+// Invite lets someone sign up while registration is invite-only, only the
+// hash of the code is stored.
+type Invite struct {
+	Id        string  `db:"id" json:"id"`
+	Code      string  `db:"-" json:"code,omitempty"`
+	MaxUses   int     `db:"max_uses" json:"maxUses"`
+	Uses      int     `db:"uses" json:"uses"`
+	ExpiresAt *string `db:"expires_at" json:"expiresAt"`
+	CreatedAt string  `db:"createdat" json:"createdAt"`
+}
+
+type InviteReq struct {
+	UserId  int `json:"-"`
+	MaxUses int `json:"maxUses"`
+	// ExpiresIn is in seconds, zero means the invite does not expire
+	ExpiresIn int `json:"expiresIn"`
+}
+
+type JSONInviteReq struct {
+	Invite *InviteReq `json:"invite"`
+}
+
+type JSONInvite struct {
+	Invite *Invite `json:"invite"`
+}
+
+type JSONInvites struct {
+	Invites []*Invite `json:"invites"`
+}
+
+// UserExport is the copy of the personal data a user can download.
+type UserExport struct {
+	ExportedAt string           `json:"exportedAt"`
+	Profile    *ExportProfile   `json:"profile"`
+	Articles   []*ExportArticle `json:"articles"`
+	Comments   []*ExportComment `json:"comments"`
+	Favorites  []string         `json:"favorites"`
+	Following  []string         `json:"following"`
+}
+
+type ExportProfile struct {
+	Username   string  `json:"username"`
+	Email      string  `json:"email"`
+	Image      *string `json:"image"`
+	Bio        *string `json:"bio"`
+	Role       Role    `json:"role"`
+	VerifiedAt *string `json:"verifiedAt"`
+	CreatedAt  string  `json:"createdAt"`
+	UpdatedAt  string  `json:"updatedAt"`
+}
+
+type ExportArticle struct {
+	Slug        string   `json:"slug"`
+	Title       string   `json:"title"`
+	Description *string  `json:"description"`
+	Body        *string  `json:"body"`
+	TagList     []string `json:"tagList"`
+	CreatedAt   string   `json:"createdAt"`
+	UpdatedAt   string   `json:"updatedAt"`
+}
+
+type ExportComment struct {
+	Id        int    `json:"id"`
+	Article   string `json:"article"`
+	Body      string `json:"body"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+type UserDeleteReq struct {
+	User struct {
+		Password string `json:"password" form:"password"`
+	} `json:"user"`
+}
+
+type UserDeletion struct {
+	ScheduledAt time.Time `json:"scheduledAt"`
+}
+
+type JSONUserDeletion struct {
+	Deletion *UserDeletion `json:"deletion"`
+}
+
+type TokenPurpose string
+
+const (
+	PasswordResetPurpose TokenPurpose = "password_reset"
+	EmailVerifyPurpose   TokenPurpose = "email_verify"
+	MagicLinkPurpose     TokenPurpose = "magic_link"
+	EmailChangePurpose   TokenPurpose = "email_change"
+)
+
+// UserActionToken is a single-use token sent to the user by email, only the
+// hash of the token is stored.
+type UserActionToken struct {
+	Id        string       `db:"id" json:"id"`
+	UserId    int          `db:"user_id" json:"user_id"`
+	Purpose   TokenPurpose `db:"purpose" json:"purpose"`
+	TokenHash string       `db:"token_hash" json:"-"`
+	Payload   *string      `db:"payload" json:"-"`
+	ExpiresAt time.Time    `db:"expires_at" json:"expires_at"`
+}
+
+type PasswordForgotReq struct {
+	User struct {
+		Email string `json:"email" form:"email"`
+	} `json:"user"`
+}
+
+type EmailVerifyReq struct {
+	User struct {
+		Token string `json:"token" form:"token"`
+	} `json:"user"`
+}
+
+type PasswordChange struct {
+	CurrentPassword string `json:"currentPassword" form:"currentPassword"`
+	NewPassword     string `json:"newPassword" form:"newPassword"`
+}
+
+type PasswordChangeReq struct {
+	User PasswordChange `json:"user"`
+}
+
+type EmailChange struct {
+	Email    string `json:"email" form:"email"`
+	Password string `json:"password" form:"password"`
+}
+
+type EmailChangeReq struct {
+	User EmailChange `json:"user"`
+}
+
+type MagicLinkReq struct {
+	User struct {
+		Email string `json:"email" form:"email"`
+	} `json:"user"`
+}
+
+type MagicLinkVerifyReq struct {
+	User struct {
+		Token string `json:"token" form:"token"`
+	} `json:"user"`
+}
+
+type PasswordReset struct {
+	Token    string `json:"token" form:"token"`
+	Password string `json:"password" form:"password"`
+}
+
+type PasswordResetReq struct {
+	User PasswordReset `json:"user"`
+}
+
+// LoginLockedError is returned while an account or ip is throttled.
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return "too many failed login attempts, try again later"
+}
+
+type UserTotp struct {
+	Secret    *string    `db:"totp_secret"`
+	EnabledAt *time.Time `db:"totp_enabled_at"`
+	LastStep  int64      `db:"totp_last_step"`
+}
+
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"`
+}
+
+type JSONTwoFactorSetup struct {
+	TwoFactor *TwoFactorSetup `json:"twoFactor"`
+}
+
+type TwoFactorCodeReq struct {
+	TwoFactor struct {
+		Code string `json:"code" form:"code"`
+	} `json:"twoFactor"`
+}
+
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// TwoFactorChallenge is returned by login in place of the passport when the
+// account has two-factor authentication enabled.
+type TwoFactorChallenge struct {
+	ChallengeToken string `json:"challengeToken"`
+	ExpiresIn      int    `json:"expiresIn"`
+}
+
+type ResponseTwoFactorChallenge struct {
+	TwoFactor *TwoFactorChallenge `json:"twoFactor"`
+}
+
+type TwoFactorLogin struct {
+	ChallengeToken string `json:"challengeToken" form:"challengeToken"`
+	// Code is either a totp code or a recovery code
+	Code string `json:"code" form:"code"`
+}
+
+type TwoFactorLoginReq struct {
+	User TwoFactorLogin `json:"user"`
+}
+
+// OidcState is a pending OpenID Connect login, kept until the provider
+// redirects the user back.
+type OidcState struct {
+	StateHash    string `db:"state_hash"`
+	Provider     string `db:"provider"`
+	Nonce        string `db:"nonce"`
+	CodeVerifier string `db:"code_verifier"`
+}
+
+// UserIdentity links an account of an OpenID Connect provider to a user.
+type UserIdentity struct {
+	UserId   int    `db:"user_id"`
+	Provider string `db:"provider"`
+	Subject  string `db:"subject"`
+	Email    string `db:"email"`
+}
+
+type OidcAuthorization struct {
+	AuthorizationUrl string `json:"authorizationUrl"`
+	State            string `json:"state"`
+}
+
+type JSONOidcAuthorization struct {
+	Oidc *OidcAuthorization `json:"oidc"`
+}
+
+type OidcCallback struct {
+	Code  string `json:"code" form:"code"`
+	State string `json:"state" form:"state"`
+}
+
+type OidcCallbackReq struct {
+	Oidc OidcCallback `json:"oidc"`
 }
 
 // type UserRemoveCredential struct {
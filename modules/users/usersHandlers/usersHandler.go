@@ -1,11 +1,17 @@
 package usershandlers
 
 import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
 	"github.com/NattpkJsw/real-world-api-go/config"
 	"github.com/NattpkJsw/real-world-api-go/modules/entities"
 	"github.com/NattpkJsw/real-world-api-go/modules/users"
 	usersUsecases "github.com/NattpkJsw/real-world-api-go/modules/users/usersUsecases"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 type userHandlersErrCode string
@@ -17,14 +23,76 @@ const (
 	logOutErr          userHandlersErrCode = "users-004"
 	getUserErr         userHandlersErrCode = "users-005"
 	UpdateUserErr      userHandlersErrCode = "users-006"
+	getSessionsErr     userHandlersErrCode = "users-007"
+	deleteSessionErr   userHandlersErrCode = "users-008"
+	deleteSessionsErr  userHandlersErrCode = "users-009"
+	createApiKeyErr    userHandlersErrCode = "users-010"
+	getApiKeysErr      userHandlersErrCode = "users-011"
+	deleteApiKeyErr    userHandlersErrCode = "users-012"
+	forgotPasswordErr  userHandlersErrCode = "users-013"
+	resetPasswordErr   userHandlersErrCode = "users-014"
+	verifyEmailErr     userHandlersErrCode = "users-015"
+	resendVerifyErr    userHandlersErrCode = "users-016"
+	logInLockedErr     userHandlersErrCode = "users-017"
+	updateUserRoleErr  userHandlersErrCode = "users-018"
+	logInTwoFactorErr  userHandlersErrCode = "users-019"
+	setupTwoFactorErr  userHandlersErrCode = "users-020"
+	confirmTwoFactErr  userHandlersErrCode = "users-021"
+	disableTwoFactErr  userHandlersErrCode = "users-022"
+	recoveryCodesErr   userHandlersErrCode = "users-023"
+	oidcAuthorizeErr   userHandlersErrCode = "users-024"
+	oidcLogInErr       userHandlersErrCode = "users-025"
+	magicLinkErr       userHandlersErrCode = "users-026"
+	logInMagicLinkErr  userHandlersErrCode = "users-027"
+	createInviteErr    userHandlersErrCode = "users-028"
+	getInvitesErr      userHandlersErrCode = "users-029"
+	deleteInviteErr    userHandlersErrCode = "users-030"
+	exportUserErr      userHandlersErrCode = "users-031"
+	deleteUserErr      userHandlersErrCode = "users-032"
+	changePasswordErr  userHandlersErrCode = "users-033"
+	changeEmailErr     userHandlersErrCode = "users-034"
+	confirmEmailErr    userHandlersErrCode = "users-035"
+	impersonateErr     userHandlersErrCode = "users-036"
+	loginHistoryErr    userHandlersErrCode = "users-037"
 )
 
 type IUsersHandler interface {
 	SignUp(c *fiber.Ctx) error
 	LogIn(c *fiber.Ctx) error
+	LogInTwoFactor(c *fiber.Ctx) error
 	LogOut(c *fiber.Ctx) error
 	GetUser(c *fiber.Ctx) error
 	UpdateUser(c *fiber.Ctx) error
+	RefreshPassport(c *fiber.Ctx) error
+	GetSessions(c *fiber.Ctx) error
+	DeleteSession(c *fiber.Ctx) error
+	DeleteSessions(c *fiber.Ctx) error
+	CreateApiKey(c *fiber.Ctx) error
+	GetApiKeys(c *fiber.Ctx) error
+	DeleteApiKey(c *fiber.Ctx) error
+	ForgotPassword(c *fiber.Ctx) error
+	ResetPassword(c *fiber.Ctx) error
+	VerifyEmail(c *fiber.Ctx) error
+	ResendVerification(c *fiber.Ctx) error
+	UpdateUserRole(c *fiber.Ctx) error
+	SetupTwoFactor(c *fiber.Ctx) error
+	ConfirmTwoFactor(c *fiber.Ctx) error
+	DisableTwoFactor(c *fiber.Ctx) error
+	RegenerateRecoveryCodes(c *fiber.Ctx) error
+	OidcAuthorize(c *fiber.Ctx) error
+	OidcLogIn(c *fiber.Ctx) error
+	SendMagicLink(c *fiber.Ctx) error
+	LogInMagicLink(c *fiber.Ctx) error
+	CreateInvite(c *fiber.Ctx) error
+	GetInvites(c *fiber.Ctx) error
+	DeleteInvite(c *fiber.Ctx) error
+	ExportUser(c *fiber.Ctx) error
+	DeleteUser(c *fiber.Ctx) error
+	ChangePassword(c *fiber.Ctx) error
+	ChangeEmail(c *fiber.Ctx) error
+	ConfirmEmailChange(c *fiber.Ctx) error
+	Impersonate(c *fiber.Ctx) error
+	GetLoginHistory(c *fiber.Ctx) error
 }
 
 type usersHandler struct {
@@ -39,6 +107,22 @@ func UsersHandler(cfg config.IConfig, usersUsecase usersUsecases.IUsersUsecase)
 	}
 }
 
+func userDevice(c *fiber.Ctx) *users.UserDevice {
+	return &users.UserDevice{
+		Ip:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	}
+}
+
+func loginLocked(c *fiber.Ctx, err *users.LoginLockedError) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds()))))
+	return entities.NewResponse(c).Error(
+		fiber.StatusTooManyRequests,
+		string(logInLockedErr),
+		err.Error(),
+	).Res()
+}
+
 func (h *usersHandler) SignUp(c *fiber.Ctx) error {
 	// Request body parser
 	req := &users.RegisterReq{}
@@ -60,8 +144,16 @@ func (h *usersHandler) SignUp(c *fiber.Ctx) error {
 		).Res()
 	}
 	// Insert
-	result, err := h.usersUsecase.InsertCustomer(req.User)
+	result, err := h.usersUsecase.InsertCustomer(req.User, userDevice(c))
 	if err != nil {
+		var lockedErr *users.LoginLockedError
+		if errors.As(err, &lockedErr) {
+			return loginLocked(c, lockedErr)
+		}
+		var validationErr *entities.ValidationError
+		if errors.As(err, &validationErr) {
+			return entities.NewResponse(c).Invalid(string(signUpErr), validationErr).Res()
+		}
 		switch err.Error() {
 		case "username has been used":
 			return entities.NewResponse(c).Error(
@@ -75,6 +167,12 @@ func (h *usersHandler) SignUp(c *fiber.Ctx) error {
 				string(signUpErr),
 				err.Error(),
 			).Res()
+		case "registration is closed", "invite code is required", "invite is invalid or expired":
+			return entities.NewResponse(c).Error(
+				fiber.ErrForbidden.Code,
+				string(signUpErr),
+				err.Error(),
+			).Res()
 		default:
 			return entities.NewResponse(c).Error(
 				fiber.ErrInternalServerError.Code, //500
@@ -96,14 +194,57 @@ func (h *usersHandler) LogIn(c *fiber.Ctx) error {
 		).Res()
 	}
 
-	passport, err := h.usersUsecase.GetPassport(&req.User)
+	passport, challenge, err := h.usersUsecase.GetPassport(&req.User, userDevice(c))
 	if err != nil {
+		var lockedErr *users.LoginLockedError
+		if errors.As(err, &lockedErr) {
+			return loginLocked(c, lockedErr)
+		}
 		return entities.NewResponse(c).Error(
 			fiber.ErrBadRequest.Code,
 			string(logInErr),
 			err.Error(),
 		).Res()
 	}
+	if challenge != nil {
+		return entities.NewResponse(c).Success(fiber.StatusOK, &users.ResponseTwoFactorChallenge{
+			TwoFactor: challenge,
+		}).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, passport).Res()
+}
+
+func (h *usersHandler) LogInTwoFactor(c *fiber.Ctx) error {
+	req := new(users.TwoFactorLoginReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(logInTwoFactorErr),
+			err.Error(),
+		).Res()
+	}
+
+	passport, err := h.usersUsecase.LoginTwoFactor(&req.User, userDevice(c))
+	if err != nil {
+		var lockedErr *users.LoginLockedError
+		if errors.As(err, &lockedErr) {
+			return loginLocked(c, lockedErr)
+		}
+		switch err.Error() {
+		case "challenge token is invalid", "code is invalid", "two-factor authentication is not enabled":
+			return entities.NewResponse(c).Error(
+				fiber.ErrUnauthorized.Code,
+				string(logInTwoFactorErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(logInTwoFactorErr),
+				err.Error(),
+			).Res()
+		}
+	}
 	return entities.NewResponse(c).Success(fiber.StatusOK, passport).Res()
 }
 
@@ -165,12 +306,958 @@ func (h *usersHandler) UpdateUser(c *fiber.Ctx) error {
 	req.User.AccessToken = token
 	req.User.Id = userId
 	ret, err := h.usersUsecase.UpdateUser(&req.User)
+	if err != nil {
+		switch err.Error() {
+		case "password cannot be changed here", "email cannot be changed here":
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(UpdateUserErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(UpdateUserErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, ret).Res()
+}
+
+func (h *usersHandler) RefreshPassport(c *fiber.Ctx) error {
+	req := new(users.OauthRefreshToken)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(refreshPassportErr),
+			err.Error(),
+		).Res()
+	}
+
+	passport, err := h.usersUsecase.RefreshPassport(req.RefreshToken)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrUnauthorized.Code,
+			string(refreshPassportErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, passport).Res()
+}
+
+func (h *usersHandler) GetSessions(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	token := c.Locals("accessToken").(string)
+
+	result, err := h.usersUsecase.GetSessions(userId, token)
 	if err != nil {
 		return entities.NewResponse(c).Error(
 			fiber.ErrInternalServerError.Code,
-			string(UpdateUserErr),
+			string(getSessionsErr),
 			err.Error(),
 		).Res()
 	}
-	return entities.NewResponse(c).Success(fiber.StatusOK, ret).Res()
+	return entities.NewResponse(c).Success(fiber.StatusOK, result).Res()
+}
+
+func (h *usersHandler) GetLoginHistory(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+
+	result, err := h.usersUsecase.GetLoginHistory(userId)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(loginHistoryErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, result).Res()
+}
+
+func (h *usersHandler) DeleteSession(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	sessionId := strings.TrimSpace(c.Params("id"))
+	if _, err := uuid.Parse(sessionId); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(deleteSessionErr),
+			"session id is invalid",
+		).Res()
+	}
+
+	if err := h.usersUsecase.DeleteSession(userId, sessionId); err != nil {
+		switch err.Error() {
+		case "session not found":
+			return entities.NewResponse(c).Error(
+				fiber.ErrNotFound.Code,
+				string(deleteSessionErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(deleteSessionErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	return entities.NewResponse(c).Success(fiber.StatusNoContent, nil).Res()
+}
+
+func (h *usersHandler) DeleteSessions(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+
+	if err := h.usersUsecase.DeleteSessions(userId); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(deleteSessionsErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusNoContent, nil).Res()
+}
+
+func (h *usersHandler) CreateApiKey(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	// An api key must not be able to mint further keys
+	if c.Locals("apiKeyId") != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrForbidden.Code,
+			string(createApiKeyErr),
+			"api keys cannot be managed with an api key",
+		).Res()
+	}
+
+	req := new(users.JSONApiKeyReq)
+	if err := c.BodyParser(req); err != nil || req.ApiKey == nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(createApiKeyErr),
+			"api key request is invalid",
+		).Res()
+	}
+	req.ApiKey.UserId = userId
+
+	result, err := h.usersUsecase.CreateApiKey(req.ApiKey)
+	if err != nil {
+		switch err.Error() {
+		case "api key name is required", "api key scope is invalid":
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(createApiKeyErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(createApiKeyErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	return entities.NewResponse(c).Success(fiber.StatusCreated, result).Res()
+}
+
+func (h *usersHandler) GetApiKeys(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+
+	result, err := h.usersUsecase.GetApiKeys(userId)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(getApiKeysErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, result).Res()
+}
+
+func (h *usersHandler) DeleteApiKey(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	if c.Locals("apiKeyId") != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrForbidden.Code,
+			string(deleteApiKeyErr),
+			"api keys cannot be managed with an api key",
+		).Res()
+	}
+
+	apiKeyId := strings.TrimSpace(c.Params("id"))
+	if _, err := uuid.Parse(apiKeyId); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(deleteApiKeyErr),
+			"api key id is invalid",
+		).Res()
+	}
+
+	if err := h.usersUsecase.DeleteApiKey(userId, apiKeyId); err != nil {
+		switch err.Error() {
+		case "api key not found":
+			return entities.NewResponse(c).Error(
+				fiber.ErrNotFound.Code,
+				string(deleteApiKeyErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(deleteApiKeyErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	return entities.NewResponse(c).Success(fiber.StatusNoContent, nil).Res()
+}
+
+func (h *usersHandler) ForgotPassword(c *fiber.Ctx) error {
+	req := new(users.PasswordForgotReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(forgotPasswordErr),
+			err.Error(),
+		).Res()
+	}
+
+	if err := h.usersUsecase.ForgotPassword(req.User.Email); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(forgotPasswordErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusAccepted, nil).Res()
+}
+
+func (h *usersHandler) ResetPassword(c *fiber.Ctx) error {
+	req := new(users.PasswordResetReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(resetPasswordErr),
+			err.Error(),
+		).Res()
+	}
+
+	if err := h.usersUsecase.ResetPassword(&req.User); err != nil {
+		var validationErr *entities.ValidationError
+		if errors.As(err, &validationErr) {
+			return entities.NewResponse(c).Invalid(string(resetPasswordErr), validationErr).Res()
+		}
+		switch err.Error() {
+		case "password is required", "token is invalid or expired":
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(resetPasswordErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(resetPasswordErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *usersHandler) VerifyEmail(c *fiber.Ctx) error {
+	req := new(users.EmailVerifyReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(verifyEmailErr),
+			err.Error(),
+		).Res()
+	}
+
+	if err := h.usersUsecase.VerifyEmail(req.User.Token); err != nil {
+		switch err.Error() {
+		case "token is invalid or expired":
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(verifyEmailErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(verifyEmailErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *usersHandler) ResendVerification(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	if userId == 0 {
+		return entities.NewResponse(c).Error(
+			fiber.ErrUnauthorized.Code,
+			string(resendVerifyErr),
+			"no permission to access",
+		).Res()
+	}
+
+	if err := h.usersUsecase.ResendVerification(userId); err != nil {
+		switch err.Error() {
+		case "email is already verified":
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(resendVerifyErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(resendVerifyErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	return entities.NewResponse(c).Success(fiber.StatusAccepted, nil).Res()
+}
+
+func (h *usersHandler) UpdateUserRole(c *fiber.Ctx) error {
+	username := strings.TrimSpace(c.Params("username"))
+	req := new(users.UserRoleReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(updateUserRoleErr),
+			err.Error(),
+		).Res()
+	}
+
+	profile, err := h.usersUsecase.UpdateUserRole(username, req.User.Role)
+	if err != nil {
+		switch err.Error() {
+		case "role is invalid":
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(updateUserRoleErr),
+				err.Error(),
+			).Res()
+		case "user not found":
+			return entities.NewResponse(c).Error(
+				fiber.ErrNotFound.Code,
+				string(updateUserRoleErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(updateUserRoleErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, profile).Res()
+}
+
+func twoFactorError(c *fiber.Ctx, errCode userHandlersErrCode, err error) error {
+	var lockedErr *users.LoginLockedError
+	if errors.As(err, &lockedErr) {
+		return loginLocked(c, lockedErr)
+	}
+	switch err.Error() {
+	case "code is invalid",
+		"two-factor authentication is already enabled",
+		"two-factor authentication is not enabled",
+		"two-factor authentication is not set up":
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(errCode),
+			err.Error(),
+		).Res()
+	default:
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(errCode),
+			err.Error(),
+		).Res()
+	}
+}
+
+func (h *usersHandler) SetupTwoFactor(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	if c.Locals("apiKeyId") != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrForbidden.Code,
+			string(setupTwoFactorErr),
+			"two-factor authentication cannot be managed with an api key",
+		).Res()
+	}
+
+	result, err := h.usersUsecase.SetupTwoFactor(userId)
+	if err != nil {
+		return twoFactorError(c, setupTwoFactorErr, err)
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, result).Res()
+}
+
+func (h *usersHandler) ConfirmTwoFactor(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	if c.Locals("apiKeyId") != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrForbidden.Code,
+			string(confirmTwoFactErr),
+			"two-factor authentication cannot be managed with an api key",
+		).Res()
+	}
+
+	req := new(users.TwoFactorCodeReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(confirmTwoFactErr),
+			err.Error(),
+		).Res()
+	}
+
+	result, err := h.usersUsecase.ConfirmTwoFactor(userId, req.TwoFactor.Code)
+	if err != nil {
+		return twoFactorError(c, confirmTwoFactErr, err)
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, result).Res()
+}
+
+func (h *usersHandler) DisableTwoFactor(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	if c.Locals("apiKeyId") != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrForbidden.Code,
+			string(disableTwoFactErr),
+			"two-factor authentication cannot be managed with an api key",
+		).Res()
+	}
+
+	req := new(users.TwoFactorCodeReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(disableTwoFactErr),
+			err.Error(),
+		).Res()
+	}
+
+	if err := h.usersUsecase.DisableTwoFactor(userId, req.TwoFactor.Code); err != nil {
+		return twoFactorError(c, disableTwoFactErr, err)
+	}
+	return entities.NewResponse(c).Success(fiber.StatusNoContent, nil).Res()
+}
+
+func (h *usersHandler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	if c.Locals("apiKeyId") != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrForbidden.Code,
+			string(recoveryCodesErr),
+			"two-factor authentication cannot be managed with an api key",
+		).Res()
+	}
+
+	req := new(users.TwoFactorCodeReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(recoveryCodesErr),
+			err.Error(),
+		).Res()
+	}
+
+	result, err := h.usersUsecase.RegenerateRecoveryCodes(userId, req.TwoFactor.Code)
+	if err != nil {
+		return twoFactorError(c, recoveryCodesErr, err)
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, result).Res()
+}
+
+func (h *usersHandler) OidcAuthorize(c *fiber.Ctx) error {
+	provider := strings.ToLower(strings.TrimSpace(c.Params("provider")))
+
+	authorization, err := h.usersUsecase.OidcAuthorize(provider)
+	if err != nil {
+		switch err.Error() {
+		case "provider not found":
+			return entities.NewResponse(c).Error(
+				fiber.ErrNotFound.Code,
+				string(oidcAuthorizeErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadGateway.Code,
+				string(oidcAuthorizeErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, authorization).Res()
+}
+
+func (h *usersHandler) OidcLogIn(c *fiber.Ctx) error {
+	provider := strings.ToLower(strings.TrimSpace(c.Params("provider")))
+	req := new(users.OidcCallbackReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(oidcLogInErr),
+			err.Error(),
+		).Res()
+	}
+	if req.Oidc.Code == "" || req.Oidc.State == "" {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(oidcLogInErr),
+			"code and state are required",
+		).Res()
+	}
+
+	passport, challenge, err := h.usersUsecase.OidcLogin(provider, &req.Oidc, userDevice(c))
+	if err != nil {
+		switch {
+		case err.Error() == "provider not found":
+			return entities.NewResponse(c).Error(
+				fiber.ErrNotFound.Code,
+				string(oidcLogInErr),
+				err.Error(),
+			).Res()
+		case err.Error() == "state is invalid or expired",
+			err.Error() == "email is not verified by provider",
+			strings.HasPrefix(err.Error(), "exchange code failed"),
+			strings.HasPrefix(err.Error(), "id token is invalid"):
+			return entities.NewResponse(c).Error(
+				fiber.ErrUnauthorized.Code,
+				string(oidcLogInErr),
+				err.Error(),
+			).Res()
+		case err.Error() == "registration is closed":
+			return entities.NewResponse(c).Error(
+				fiber.ErrForbidden.Code,
+				string(oidcLogInErr),
+				err.Error(),
+			).Res()
+		case err.Error() == "identity has been linked",
+			err.Error() == "email has been used",
+			err.Error() == "email must be verified before linking":
+			return entities.NewResponse(c).Error(
+				fiber.ErrConflict.Code,
+				string(oidcLogInErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(oidcLogInErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	if challenge != nil {
+		return entities.NewResponse(c).Success(fiber.StatusOK, &users.ResponseTwoFactorChallenge{
+			TwoFactor: challenge,
+		}).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, passport).Res()
+}
+
+func (h *usersHandler) SendMagicLink(c *fiber.Ctx) error {
+	req := new(users.MagicLinkReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(magicLinkErr),
+			err.Error(),
+		).Res()
+	}
+
+	if err := h.usersUsecase.SendMagicLink(req.User.Email); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(magicLinkErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusAccepted, nil).Res()
+}
+
+func (h *usersHandler) LogInMagicLink(c *fiber.Ctx) error {
+	req := new(users.MagicLinkVerifyReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(logInMagicLinkErr),
+			err.Error(),
+		).Res()
+	}
+
+	passport, challenge, err := h.usersUsecase.LoginMagicLink(req.User.Token, userDevice(c))
+	if err != nil {
+		switch err.Error() {
+		case "token is invalid or expired":
+			return entities.NewResponse(c).Error(
+				fiber.ErrUnauthorized.Code,
+				string(logInMagicLinkErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(logInMagicLinkErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	if challenge != nil {
+		return entities.NewResponse(c).Success(fiber.StatusOK, &users.ResponseTwoFactorChallenge{
+			TwoFactor: challenge,
+		}).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, passport).Res()
+}
+
+func (h *usersHandler) CreateInvite(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	role := c.Locals("userRole").(users.Role)
+
+	req := new(users.JSONInviteReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(createInviteErr),
+			"invite request is invalid",
+		).Res()
+	}
+	// Every field is optional
+	if req.Invite == nil {
+		req.Invite = new(users.InviteReq)
+	}
+	req.Invite.UserId = userId
+
+	result, err := h.usersUsecase.CreateInvite(req.Invite, role)
+	if err != nil {
+		switch err.Error() {
+		case "permission denied":
+			return entities.NewResponse(c).Error(
+				fiber.ErrForbidden.Code,
+				string(createInviteErr),
+				err.Error(),
+			).Res()
+		case "invite max uses is invalid", "invite expires in is invalid":
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(createInviteErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(createInviteErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	return entities.NewResponse(c).Success(fiber.StatusCreated, result).Res()
+}
+
+func (h *usersHandler) GetInvites(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+
+	result, err := h.usersUsecase.GetInvites(userId)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(getInvitesErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, result).Res()
+}
+
+func (h *usersHandler) DeleteInvite(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	role := c.Locals("userRole").(users.Role)
+
+	inviteId := strings.TrimSpace(c.Params("id"))
+	if _, err := uuid.Parse(inviteId); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(deleteInviteErr),
+			"invite id is invalid",
+		).Res()
+	}
+
+	if err := h.usersUsecase.DeleteInvite(userId, inviteId, role); err != nil {
+		switch err.Error() {
+		case "invite not found":
+			return entities.NewResponse(c).Error(
+				fiber.ErrNotFound.Code,
+				string(deleteInviteErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(deleteInviteErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	return entities.NewResponse(c).Success(fiber.StatusNoContent, nil).Res()
+}
+
+func (h *usersHandler) ExportUser(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	if c.Locals("apiKeyId") != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrForbidden.Code,
+			string(exportUserErr),
+			"personal data cannot be exported with an api key",
+		).Res()
+	}
+
+	switch c.Query("format", "json") {
+	case "json":
+		result, err := h.usersUsecase.ExportUser(userId)
+		if err != nil {
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(exportUserErr),
+				err.Error(),
+			).Res()
+		}
+		return entities.NewResponse(c).Success(fiber.StatusOK, result).Res()
+	case "zip":
+		data, err := h.usersUsecase.ExportUserZip(userId)
+		if err != nil {
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(exportUserErr),
+				err.Error(),
+			).Res()
+		}
+		c.Set(fiber.HeaderContentType, "application/zip")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="export.zip"`)
+		return c.Status(fiber.StatusOK).Send(data)
+	default:
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(exportUserErr),
+			"format must be json or zip",
+		).Res()
+	}
+}
+
+func (h *usersHandler) DeleteUser(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	if c.Locals("apiKeyId") != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrForbidden.Code,
+			string(deleteUserErr),
+			"an account cannot be deleted with an api key",
+		).Res()
+	}
+
+	req := new(users.UserDeleteReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(deleteUserErr),
+			err.Error(),
+		).Res()
+	}
+
+	result, err := h.usersUsecase.DeleteUser(userId, req.User.Password)
+	if err != nil {
+		var lockedErr *users.LoginLockedError
+		if errors.As(err, &lockedErr) {
+			return loginLocked(c, lockedErr)
+		}
+		switch err.Error() {
+		case "password is invalid":
+			return entities.NewResponse(c).Error(
+				fiber.ErrForbidden.Code,
+				string(deleteUserErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(deleteUserErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	return entities.NewResponse(c).Success(fiber.StatusAccepted, result).Res()
+}
+
+func (h *usersHandler) ChangePassword(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	if c.Locals("apiKeyId") != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrForbidden.Code,
+			string(changePasswordErr),
+			"password cannot be changed with an api key",
+		).Res()
+	}
+	token := c.Locals("accessToken").(string)
+
+	req := new(users.PasswordChangeReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(changePasswordErr),
+			err.Error(),
+		).Res()
+	}
+
+	if err := h.usersUsecase.ChangePassword(userId, token, &req.User); err != nil {
+		var lockedErr *users.LoginLockedError
+		if errors.As(err, &lockedErr) {
+			return loginLocked(c, lockedErr)
+		}
+		var validationErr *entities.ValidationError
+		if errors.As(err, &validationErr) {
+			return entities.NewResponse(c).Invalid(string(changePasswordErr), validationErr).Res()
+		}
+		switch err.Error() {
+		case "new password is required":
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(changePasswordErr),
+				err.Error(),
+			).Res()
+		case "password is invalid", "session is required":
+			return entities.NewResponse(c).Error(
+				fiber.ErrForbidden.Code,
+				string(changePasswordErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(changePasswordErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	return entities.NewResponse(c).Success(fiber.StatusNoContent, nil).Res()
+}
+
+func (h *usersHandler) ChangeEmail(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	if c.Locals("apiKeyId") != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrForbidden.Code,
+			string(changeEmailErr),
+			"email cannot be changed with an api key",
+		).Res()
+	}
+
+	req := new(users.EmailChangeReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(changeEmailErr),
+			err.Error(),
+		).Res()
+	}
+
+	if err := h.usersUsecase.RequestEmailChange(userId, &req.User); err != nil {
+		var lockedErr *users.LoginLockedError
+		if errors.As(err, &lockedErr) {
+			return loginLocked(c, lockedErr)
+		}
+		switch err.Error() {
+		case "email pattern is invalid", "email is unchanged", "email has been used":
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(changeEmailErr),
+				err.Error(),
+			).Res()
+		case "password is invalid":
+			return entities.NewResponse(c).Error(
+				fiber.ErrForbidden.Code,
+				string(changeEmailErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(changeEmailErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	return entities.NewResponse(c).Success(fiber.StatusAccepted, nil).Res()
+}
+
+func (h *usersHandler) ConfirmEmailChange(c *fiber.Ctx) error {
+	req := new(users.EmailVerifyReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(confirmEmailErr),
+			err.Error(),
+		).Res()
+	}
+
+	if err := h.usersUsecase.ConfirmEmailChange(req.User.Token); err != nil {
+		switch err.Error() {
+		case "token is invalid or expired", "email has been used":
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(confirmEmailErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(confirmEmailErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *usersHandler) Impersonate(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(int)
+	username := strings.TrimSpace(c.Params("username"))
+
+	result, err := h.usersUsecase.Impersonate(userId, username, userDevice(c))
+	if err != nil {
+		switch err.Error() {
+		case "cannot impersonate yourself":
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(impersonateErr),
+				err.Error(),
+			).Res()
+		case "admins cannot be impersonated":
+			return entities.NewResponse(c).Error(
+				fiber.ErrForbidden.Code,
+				string(impersonateErr),
+				err.Error(),
+			).Res()
+		case "user not found":
+			return entities.NewResponse(c).Error(
+				fiber.ErrNotFound.Code,
+				string(impersonateErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(impersonateErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	return entities.NewResponse(c).Success(fiber.StatusCreated, result).Res()
 }
@@ -2,12 +2,14 @@ package usersrepositories
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/NattpkJsw/real-world-api-go/modules/users"
 	userspatterns "github.com/NattpkJsw/real-world-api-go/modules/users/usersPatterns"
+	"github.com/NattpkJsw/real-world-api-go/pkg/utils"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -20,6 +22,50 @@ type IUsersRepository interface {
 	GetProfile(userId int) (*users.User, error)
 	DeleteOauth(accessToken string) error
 	UpdateUser(user *users.UserCredentialCheck) (*users.User, error)
+	FindOneOauthByRefreshToken(refreshToken string) (*users.Oauth, error)
+	FindOauthIdByUsedRefreshToken(refreshToken string) (string, error)
+	RotateOauth(req *users.UserToken, usedRefreshToken string) error
+	DeleteOauthById(oauthId string) error
+	FindSessions(userId int, accessToken string) ([]*users.Session, error)
+	DeleteSession(userId int, oauthId string) error
+	DeleteSessions(userId int) error
+	InsertApiKey(req *users.ApiKeyReq) (*users.ApiKey, error)
+	FindApiKeys(userId int) ([]*users.ApiKey, error)
+	DeleteApiKey(userId int, apiKeyId string) error
+	InsertUserToken(req *users.UserActionToken, expiresIn int) error
+	FindUserToken(purpose users.TokenPurpose, tokenHash string) (*users.UserActionToken, error)
+	ResetPassword(token *users.UserActionToken, password string) error
+	VerifyEmail(token *users.UserActionToken) error
+	UpdateUserRole(username string, role users.Role) (*users.User, error)
+	FindUserTotp(userId int) (*users.UserTotp, error)
+	SetTotpSecret(userId int, secret string) error
+	EnableTotp(userId int, step int64, codeHashes []string) error
+	DisableTotp(userId int) error
+	UseTotpStep(userId int, step int64) error
+	UseRecoveryCode(userId int, codeHash string) error
+	ReplaceRecoveryCodes(userId int, codeHashes []string) error
+	InsertOidcState(req *users.OidcState, expiresIn int) error
+	ConsumeOidcState(provider, stateHash string) (*users.OidcState, error)
+	FindUserIdentity(provider, subject string) (int, error)
+	LinkUserIdentity(req *users.UserIdentity) error
+	InsertOidcUser(req *users.UserRegisterReq, identity *users.UserIdentity) (int, error)
+	InsertInvite(req *users.InviteReq, codeHash string) (*users.Invite, error)
+	FindInvites(userId int) ([]*users.Invite, error)
+	DeleteInvite(userId int, inviteId string, canManage bool) error
+	UseInvite(codeHash string) (string, error)
+	ReleaseInvite(inviteId string) error
+	FindUserPassword(userId int) (string, error)
+	FindUserExport(userId int) (*users.UserExport, error)
+	ScheduleUserDeletion(userId int, gracePeriod int) (time.Time, error)
+	CancelUserDeletion(userId int) (bool, error)
+	PurgeDeletedUsers(anonymize bool) (int, error)
+	ChangePassword(userId int, password string, keepAccessToken string) error
+	ChangeEmail(token *users.UserActionToken) error
+	FindOneUserByUsername(username string) (*users.User, error)
+	InsertImpersonation(req *users.UserToken, impersonatorId int) error
+	InsertLoginEvent(req *users.LoginEvent) error
+	FindLoginHistory(userId int, limit int) ([]*users.LoginEvent, error)
+	IsNewLoginDevice(userId int, deviceFingerprint string) (bool, error)
 }
 
 type usersRepository struct {
@@ -58,9 +104,12 @@ func (r *usersRepository) FindOneUserByEmail(email string) (*users.UserCredentia
 		"password",
 		"username",
 		"image",
-		"bio"
+		"bio",
+		"role",
+		"totp_enabled_at" IS NOT NULL AS "totp_enabled",
+		"verified_at"
 	FROM "users"
-	WHERE "email" = $1;`
+	WHERE lower("email") = $1;`
 
 	user := new(users.UserCredentialCheck)
 	if err := r.db.Get(user, query, strings.ToLower(email)); err != nil {
@@ -76,16 +125,27 @@ func (r *usersRepository) InsertOauth(req *users.UserToken) error {
 	query := `
 	INSERT INTO "oauth" (
 		"user_id",
-		"access_token"
+		"access_token_hash",
+		"access_jti",
+		"access_expires_at",
+		"refresh_token_hash",
+		"ip",
+		"user_agent",
+		"last_used_at"
 	)
-	VALUES ($1, $2)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP)
 		RETURNING "id";`
 
 	if err := r.db.QueryRowContext(
 		ctx,
 		query,
 		req.User_Id,
-		req.AccessToken,
+		utils.HashToken(req.AccessToken),
+		req.AccessJti,
+		req.AccessExpiresAt,
+		utils.HashToken(req.RefreshToken),
+		req.Ip,
+		req.UserAgent,
 	).Scan(&req.Id); err != nil {
 		return fmt.Errorf("insert oauth failed: %v", err)
 	}
@@ -94,8 +154,8 @@ func (r *usersRepository) InsertOauth(req *users.UserToken) error {
 
 func (r *usersRepository) DeleteOauth(accessToken string) error {
 	query := `
-	DELETE FROM "oauth" WHERE "access_token" = $1;`
-	if _, err := r.db.ExecContext(context.Background(), query, accessToken); err != nil {
+	DELETE FROM "oauth" WHERE "access_token_hash" = $1;`
+	if _, err := r.db.ExecContext(context.Background(), query, utils.HashToken(accessToken)); err != nil {
 		return fmt.Errorf("oauth not found ")
 	}
 	return nil
@@ -108,7 +168,9 @@ func (r *usersRepository) GetProfile(userId int) (*users.User, error) {
 		"email",
 		"username",
 		"image",
-		"bio"
+		"bio",
+		"verified_at",
+		"role"
 	FROM "users"
 	WHERE "id" = $1;`
 
@@ -120,11 +182,15 @@ func (r *usersRepository) GetProfile(userId int) (*users.User, error) {
 }
 
 func (r *usersRepository) UpdateUser(user *users.UserCredentialCheck) (*users.User, error) {
-	// Build the update query dynamically
+	// Build the update query dynamically. The email and the password are not
+	// part of it, they have their own flows asking for the password.
 	query, params, err := buildUpdateQuery(user)
 	if err != nil {
 		return nil, err
 	}
+	if query == "" {
+		return r.GetProfile(user.Id)
+	}
 
 	// Execute the query
 	if _, err := r.db.NamedExec(query, params); err != nil {
@@ -146,12 +212,6 @@ func buildUpdateQuery(user *users.UserCredentialCheck) (string, map[string]inter
 		params["username"] = user.Username
 	}
 
-	if user.Email != "" {
-		query += ` 
-		"email" = :email,`
-		params["email"] = user.Email
-	}
-
 	if user.Bio != nil {
 		query += ` 
 		"bio" = :bio,`
@@ -164,12 +224,9 @@ func buildUpdateQuery(user *users.UserCredentialCheck) (string, map[string]inter
 		params["image"] = user.Image
 	}
 
-	if user.Password != "" {
-		if err := user.BcryptHashingUpdate(); err != nil {
-			return "", nil, fmt.Errorf("hash password failed: %v", err)
-		}
-		query += ` "password" = :password,`
-		params["password"] = user.Password
+	// Nothing to update
+	if len(params) == 1 {
+		return "", params, nil
 	}
 	// Remove the trailing comma
 	query = query[:len(query)-1]
@@ -182,10 +239,12 @@ func buildUpdateQuery(user *users.UserCredentialCheck) (string, map[string]inter
 func (r *usersRepository) UpdateOauth(req *users.UserToken) error {
 	query := `
 	UPDATE "oauth" SET
-		"access_token" = :access_token
-	WHERE "id" = :id;`
+		"access_token_hash" = $1,
+		"access_jti" = $2,
+		"access_expires_at" = $3
+	WHERE "id" = $4;`
 
-	if _, err := r.db.NamedExecContext(context.Background(), query, req); err != nil {
+	if _, err := r.db.ExecContext(context.Background(), query, utils.HashToken(req.AccessToken), req.AccessJti, req.AccessExpiresAt, req.Id); err != nil {
 		return fmt.Errorf("update oauth failed: %v", err)
 	}
 	return nil
@@ -194,14 +253,1374 @@ func (r *usersRepository) UpdateOauth(req *users.UserToken) error {
 func (r *usersRepository) FindOneOath(accessToken string) (*users.Oauth, error) {
 	query := `
 	SELECT
-		"id",
-		"user_id"
-	FROM "oauth"
-	WHERE "access_token" = $1;`
+		"o"."id",
+		"o"."user_id",
+		"u"."role",
+		COALESCE("o"."impersonator_id", 0) AS "impersonator_id",
+		"o"."access_expires_at"
+	FROM "oauth" "o"
+	JOIN "users" "u" ON "u"."id" = "o"."user_id"
+	WHERE "o"."access_token_hash" = $1;`
+
+	oauth := new(users.Oauth)
+	if err := r.db.Get(oauth, query, utils.HashToken(accessToken)); err != nil {
+		return nil, fmt.Errorf("oauth not found, %v", err)
+	}
+	return oauth, nil
+}
+
+func (r *usersRepository) FindOneOauthByRefreshToken(refreshToken string) (*users.Oauth, error) {
+	query := `
+	SELECT
+		"o"."id",
+		"o"."user_id",
+		"u"."role"
+	FROM "oauth" "o"
+	JOIN "users" "u" ON "u"."id" = "o"."user_id"
+	WHERE "o"."refresh_token_hash" = $1;`
 
 	oauth := new(users.Oauth)
-	if err := r.db.Get(oauth, query, accessToken); err != nil {
+	if err := r.db.Get(oauth, query, utils.HashToken(refreshToken)); err != nil {
 		return nil, fmt.Errorf("oauth not found, %v", err)
 	}
 	return oauth, nil
 }
+
+func (r *usersRepository) FindOauthIdByUsedRefreshToken(refreshToken string) (string, error) {
+	query := `
+	SELECT
+		"oauth_id"
+	FROM "oauth_refresh_history"
+	WHERE "refresh_token_hash" = $1;`
+
+	var oauthId string
+	if err := r.db.Get(&oauthId, query, utils.HashToken(refreshToken)); err != nil {
+		return "", fmt.Errorf("refresh token not found, %v", err)
+	}
+	return oauthId, nil
+}
+
+// RotateOauth swaps the token pair of an oauth row and keeps the used refresh
+// token in the history, so presenting it again can be told apart from an
+// unknown token. The update only succeeds while the row still holds
+// usedRefreshToken, which makes two concurrent rotations of one token fail.
+func (r *usersRepository) RotateOauth(req *users.UserToken, usedRefreshToken string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+
+	query := `
+	UPDATE "oauth" SET
+		"access_token_hash" = $1,
+		"access_jti" = $2,
+		"access_expires_at" = $3,
+		"refresh_token_hash" = $4,
+		"last_used_at" = CURRENT_TIMESTAMP
+	WHERE "id" = $5 AND "refresh_token_hash" = $6;`
+
+	usedHash := utils.HashToken(usedRefreshToken)
+	result, err := tx.ExecContext(
+		ctx,
+		query,
+		utils.HashToken(req.AccessToken),
+		req.AccessJti,
+		req.AccessExpiresAt,
+		utils.HashToken(req.RefreshToken),
+		req.Id,
+		usedHash,
+	)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("rotate oauth failed: %v", err)
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("getting number of affected rows failed: %v", err)
+	}
+	if rowAffected == 0 {
+		tx.Rollback()
+		return fmt.Errorf("refresh token has been used")
+	}
+
+	historyQuery := `
+	INSERT INTO "oauth_refresh_history" (
+		"oauth_id",
+		"refresh_token_hash"
+	)
+	VALUES ($1, $2);`
+
+	if _, err := tx.ExecContext(ctx, historyQuery, req.Id, usedHash); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("insert refresh history failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit error: %v", err)
+	}
+	return nil
+}
+
+func (r *usersRepository) DeleteOauthById(oauthId string) error {
+	query := `
+	DELETE FROM "oauth" WHERE "id" = $1;`
+	if _, err := r.db.ExecContext(context.Background(), query, oauthId); err != nil {
+		return fmt.Errorf("delete oauth failed: %v", err)
+	}
+	return nil
+}
+
+func (r *usersRepository) FindSessions(userId int, accessToken string) ([]*users.Session, error) {
+	query := `
+	SELECT
+		"id",
+		"ip",
+		"user_agent",
+		"createdat",
+		"last_used_at",
+		("access_token_hash" = $2) AS "current"
+	FROM "oauth"
+	WHERE "user_id" = $1
+	ORDER BY "last_used_at" DESC NULLS LAST, "createdat" DESC;`
+
+	sessions := make([]*users.Session, 0)
+	if err := r.db.Select(&sessions, query, userId, utils.HashToken(accessToken)); err != nil {
+		return nil, fmt.Errorf("get sessions failed: %v", err)
+	}
+	return sessions, nil
+}
+
+func (r *usersRepository) DeleteSession(userId int, oauthId string) error {
+	query := `
+	DELETE FROM "oauth" WHERE "id" = $1 AND "user_id" = $2;`
+
+	result, err := r.db.ExecContext(context.Background(), query, oauthId, userId)
+	if err != nil {
+		return fmt.Errorf("delete session failed: %v", err)
+	}
+
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting number of affected rows failed: %v", err)
+	}
+
+	if rowAffected == 0 {
+		return fmt.Errorf("session not found")
+	}
+	return nil
+}
+
+func (r *usersRepository) DeleteSessions(userId int) error {
+	query := `
+	DELETE FROM "oauth" WHERE "user_id" = $1;`
+	if _, err := r.db.ExecContext(context.Background(), query, userId); err != nil {
+		return fmt.Errorf("delete sessions failed: %v", err)
+	}
+	return nil
+}
+
+func (r *usersRepository) InsertApiKey(req *users.ApiKeyReq) (*users.ApiKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `
+	INSERT INTO "api_keys" (
+		"user_id",
+		"name",
+		"scope"
+	)
+	VALUES ($1, $2, $3)
+	RETURNING
+		"id",
+		"name",
+		"scope",
+		"createdat",
+		"last_used_at";`
+
+	apiKey := new(users.ApiKey)
+	if err := r.db.QueryRowxContext(
+		ctx,
+		query,
+		req.UserId,
+		req.Name,
+		req.Scope,
+	).StructScan(apiKey); err != nil {
+		return nil, fmt.Errorf("insert api key failed: %v", err)
+	}
+	return apiKey, nil
+}
+
+func (r *usersRepository) FindApiKeys(userId int) ([]*users.ApiKey, error) {
+	query := `
+	SELECT
+		"id",
+		"name",
+		"scope",
+		"createdat",
+		"last_used_at"
+	FROM "api_keys"
+	WHERE "user_id" = $1
+	ORDER BY "createdat" DESC;`
+
+	apiKeys := make([]*users.ApiKey, 0)
+	if err := r.db.Select(&apiKeys, query, userId); err != nil {
+		return nil, fmt.Errorf("get api keys failed: %v", err)
+	}
+	return apiKeys, nil
+}
+
+func (r *usersRepository) DeleteApiKey(userId int, apiKeyId string) error {
+	query := `
+	DELETE FROM "api_keys" WHERE "id" = $1 AND "user_id" = $2;`
+
+	result, err := r.db.ExecContext(context.Background(), query, apiKeyId, userId)
+	if err != nil {
+		return fmt.Errorf("delete api key failed: %v", err)
+	}
+
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting number of affected rows failed: %v", err)
+	}
+
+	if rowAffected == 0 {
+		return fmt.Errorf("api key not found")
+	}
+	return nil
+}
+
+func (r *usersRepository) InsertUserToken(req *users.UserActionToken, expiresIn int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `
+	INSERT INTO "user_tokens" (
+		"user_id",
+		"purpose",
+		"token_hash",
+		"payload",
+		"expires_at"
+	)
+	VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + make_interval(secs => $5))
+		RETURNING "id", "expires_at";`
+
+	if err := r.db.QueryRowContext(
+		ctx,
+		query,
+		req.UserId,
+		req.Purpose,
+		req.TokenHash,
+		req.Payload,
+		expiresIn,
+	).Scan(&req.Id, &req.ExpiresAt); err != nil {
+		return fmt.Errorf("insert user token failed: %v", err)
+	}
+	return nil
+}
+
+func (r *usersRepository) FindUserToken(purpose users.TokenPurpose, tokenHash string) (*users.UserActionToken, error) {
+	query := `
+	SELECT
+		"id",
+		"user_id",
+		"purpose",
+		"token_hash",
+		"payload",
+		"expires_at"
+	FROM "user_tokens"
+	WHERE "purpose" = $1
+	AND "token_hash" = $2
+	AND "used_at" IS NULL
+	AND "expires_at" > CURRENT_TIMESTAMP;`
+
+	token := new(users.UserActionToken)
+	if err := r.db.Get(token, query, purpose, tokenHash); err != nil {
+		return nil, fmt.Errorf("token is invalid or expired")
+	}
+	return token, nil
+}
+
+// consumeUserToken marks the token as used, it fails when another request
+// has used the token first. Other pending tokens of the same purpose are
+// spent too, so only the newest email stays usable until one is used.
+func consumeUserToken(ctx context.Context, tx *sqlx.Tx, token *users.UserActionToken) error {
+	query := `
+	UPDATE "user_tokens" SET
+		"used_at" = CURRENT_TIMESTAMP
+	WHERE "id" = $1 AND "used_at" IS NULL;`
+
+	result, err := tx.ExecContext(ctx, query, token.Id)
+	if err != nil {
+		return fmt.Errorf("consume user token failed: %v", err)
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting number of affected rows failed: %v", err)
+	}
+	if rowAffected == 0 {
+		return fmt.Errorf("token is invalid or expired")
+	}
+
+	otherQuery := `
+	UPDATE "user_tokens" SET
+		"used_at" = CURRENT_TIMESTAMP
+	WHERE "user_id" = $1 AND "purpose" = $2 AND "used_at" IS NULL;`
+
+	if _, err := tx.ExecContext(ctx, otherQuery, token.UserId, token.Purpose); err != nil {
+		return fmt.Errorf("consume user token failed: %v", err)
+	}
+	return nil
+}
+
+// ResetPassword sets the new password hash, spends the reset token and logs
+// the user out of every session in one transaction.
+func (r *usersRepository) ResetPassword(token *users.UserActionToken, password string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+
+	if err := consumeUserToken(ctx, tx, token); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	query := `
+	UPDATE "users" SET
+		"password" = $1
+	WHERE "id" = $2;`
+
+	if _, err := tx.ExecContext(ctx, query, password, token.UserId); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("update password failed: %v", err)
+	}
+
+	oauthQuery := `
+	DELETE FROM "oauth" WHERE "user_id" = $1;`
+
+	if _, err := tx.ExecContext(ctx, oauthQuery, token.UserId); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("delete oauth failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit error: %v", err)
+	}
+	return nil
+}
+
+func (r *usersRepository) VerifyEmail(token *users.UserActionToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+
+	if err := consumeUserToken(ctx, tx, token); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	query := `
+	UPDATE "users" SET
+		"verified_at" = CURRENT_TIMESTAMP
+	WHERE "id" = $1 AND "verified_at" IS NULL;`
+
+	if _, err := tx.ExecContext(ctx, query, token.UserId); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("verify email failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit error: %v", err)
+	}
+	return nil
+}
+
+// UpdateUserRole also ends the sessions of the user, the role is carried in
+// the token claims and must not outlive a demotion.
+func (r *usersRepository) UpdateUserRole(username string, role users.Role) (*users.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction failed: %v", err)
+	}
+
+	query := `
+	UPDATE "users" SET
+		"role" = $1
+	WHERE "username" = $2
+	RETURNING "id";`
+
+	var userId int
+	if err := tx.QueryRowxContext(ctx, query, role, username).Scan(&userId); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("user not found")
+	}
+
+	// Sessions opened by the user as someone else end with the role too
+	oauthQuery := `
+	DELETE FROM "oauth" WHERE "user_id" = $1 OR "impersonator_id" = $1;`
+
+	if _, err := tx.ExecContext(ctx, oauthQuery, userId); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("delete oauth failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit error: %v", err)
+	}
+	return r.GetProfile(userId)
+}
+
+func (r *usersRepository) FindUserTotp(userId int) (*users.UserTotp, error) {
+	query := `
+	SELECT
+		"totp_secret",
+		"totp_enabled_at",
+		"totp_last_step"
+	FROM "users"
+	WHERE "id" = $1;`
+
+	totp := new(users.UserTotp)
+	if err := r.db.Get(totp, query, userId); err != nil {
+		return nil, fmt.Errorf("get user totp failed: %v", err)
+	}
+	return totp, nil
+}
+
+// SetTotpSecret stores a pending secret, it is only switched on by
+// EnableTotp once the user proves the authenticator works.
+func (r *usersRepository) SetTotpSecret(userId int, secret string) error {
+	query := `
+	UPDATE "users" SET
+		"totp_secret" = $2,
+		"totp_last_step" = 0
+	WHERE "id" = $1 AND "totp_enabled_at" IS NULL;`
+
+	result, err := r.db.ExecContext(context.Background(), query, userId, secret)
+	if err != nil {
+		return fmt.Errorf("set totp secret failed: %v", err)
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting number of affected rows failed: %v", err)
+	}
+	if rowAffected == 0 {
+		return fmt.Errorf("two-factor authentication is already enabled")
+	}
+	return nil
+}
+
+func (r *usersRepository) EnableTotp(userId int, step int64, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+
+	query := `
+	UPDATE "users" SET
+		"totp_enabled_at" = CURRENT_TIMESTAMP,
+		"totp_last_step" = $2
+	WHERE "id" = $1
+	AND "totp_secret" IS NOT NULL
+	AND "totp_enabled_at" IS NULL
+	AND "totp_last_step" < $2;`
+
+	result, err := tx.ExecContext(ctx, query, userId, step)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("enable totp failed: %v", err)
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("getting number of affected rows failed: %v", err)
+	}
+	if rowAffected == 0 {
+		tx.Rollback()
+		return fmt.Errorf("two-factor authentication is already enabled")
+	}
+
+	if err := insertRecoveryCodes(ctx, tx, userId, codeHashes); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit error: %v", err)
+	}
+	return nil
+}
+
+func (r *usersRepository) DisableTotp(userId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+
+	query := `
+	UPDATE "users" SET
+		"totp_secret" = NULL,
+		"totp_enabled_at" = NULL,
+		"totp_last_step" = 0
+	WHERE "id" = $1;`
+
+	if _, err := tx.ExecContext(ctx, query, userId); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("disable totp failed: %v", err)
+	}
+
+	codesQuery := `
+	DELETE FROM "recovery_codes" WHERE "user_id" = $1;`
+
+	if _, err := tx.ExecContext(ctx, codesQuery, userId); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("delete recovery codes failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit error: %v", err)
+	}
+	return nil
+}
+
+// UseTotpStep moves the last accepted step forward, it fails when the step
+// has already been used by a concurrent request.
+func (r *usersRepository) UseTotpStep(userId int, step int64) error {
+	query := `
+	UPDATE "users" SET
+		"totp_last_step" = $2
+	WHERE "id" = $1 AND "totp_last_step" < $2;`
+
+	result, err := r.db.ExecContext(context.Background(), query, userId, step)
+	if err != nil {
+		return fmt.Errorf("use totp step failed: %v", err)
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting number of affected rows failed: %v", err)
+	}
+	if rowAffected == 0 {
+		return fmt.Errorf("code is invalid")
+	}
+	return nil
+}
+
+func (r *usersRepository) UseRecoveryCode(userId int, codeHash string) error {
+	query := `
+	UPDATE "recovery_codes" SET
+		"used_at" = CURRENT_TIMESTAMP
+	WHERE "user_id" = $1 AND "code_hash" = $2 AND "used_at" IS NULL;`
+
+	result, err := r.db.ExecContext(context.Background(), query, userId, codeHash)
+	if err != nil {
+		return fmt.Errorf("use recovery code failed: %v", err)
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting number of affected rows failed: %v", err)
+	}
+	if rowAffected == 0 {
+		return fmt.Errorf("code is invalid")
+	}
+	return nil
+}
+
+func (r *usersRepository) ReplaceRecoveryCodes(userId int, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+
+	query := `
+	DELETE FROM "recovery_codes" WHERE "user_id" = $1;`
+
+	if _, err := tx.ExecContext(ctx, query, userId); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("delete recovery codes failed: %v", err)
+	}
+
+	if err := insertRecoveryCodes(ctx, tx, userId, codeHashes); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit error: %v", err)
+	}
+	return nil
+}
+
+func insertRecoveryCodes(ctx context.Context, tx *sqlx.Tx, userId int, codeHashes []string) error {
+	query := `
+	INSERT INTO "recovery_codes" (
+		"user_id",
+		"code_hash"
+	)
+	SELECT $1, unnest($2::VARCHAR[]);`
+
+	if _, err := tx.ExecContext(ctx, query, userId, codeHashes); err != nil {
+		return fmt.Errorf("insert recovery codes failed: %v", err)
+	}
+	return nil
+}
+
+// InsertOidcState also clears the expired states of abandoned logins.
+func (r *usersRepository) InsertOidcState(req *users.OidcState, expiresIn int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cleanQuery := `
+	DELETE FROM "oidc_states" WHERE "expires_at" <= CURRENT_TIMESTAMP;`
+
+	if _, err := r.db.ExecContext(ctx, cleanQuery); err != nil {
+		return fmt.Errorf("delete expired oidc states failed: %v", err)
+	}
+
+	query := `
+	INSERT INTO "oidc_states" (
+		"state_hash",
+		"provider",
+		"nonce",
+		"code_verifier",
+		"expires_at"
+	)
+	VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + make_interval(secs => $5));`
+
+	if _, err := r.db.ExecContext(
+		ctx,
+		query,
+		req.StateHash,
+		req.Provider,
+		req.Nonce,
+		req.CodeVerifier,
+		expiresIn,
+	); err != nil {
+		return fmt.Errorf("insert oidc state failed: %v", err)
+	}
+	return nil
+}
+
+// ConsumeOidcState deletes the state as it reads it, a state is only good
+// for one callback.
+func (r *usersRepository) ConsumeOidcState(provider, stateHash string) (*users.OidcState, error) {
+	query := `
+	DELETE FROM "oidc_states"
+	WHERE "state_hash" = $1
+	AND "provider" = $2
+	AND "expires_at" > CURRENT_TIMESTAMP
+	RETURNING "state_hash", "provider", "nonce", "code_verifier";`
+
+	state := new(users.OidcState)
+	if err := r.db.Get(state, query, stateHash, provider); err != nil {
+		return nil, fmt.Errorf("state is invalid or expired")
+	}
+	return state, nil
+}
+
+func (r *usersRepository) FindUserIdentity(provider, subject string) (int, error) {
+	query := `
+	UPDATE "user_identities" SET
+		"last_login_at" = CURRENT_TIMESTAMP
+	WHERE "provider" = $1 AND "subject" = $2
+	RETURNING "user_id";`
+
+	var userId int
+	if err := r.db.Get(&userId, query, provider, subject); err != nil {
+		return 0, fmt.Errorf("identity not found")
+	}
+	return userId, nil
+}
+
+// LinkUserIdentity adds an identity to an existing user, it leaves the user
+// verification as it is.
+func (r *usersRepository) LinkUserIdentity(req *users.UserIdentity) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+
+	if err := insertUserIdentity(ctx, tx, req); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit error: %v", err)
+	}
+	return nil
+}
+
+// InsertOidcUser creates a user together with its first identity, the
+// provider has verified the email already.
+func (r *usersRepository) InsertOidcUser(req *users.UserRegisterReq, identity *users.UserIdentity) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction failed: %v", err)
+	}
+
+	query := `
+	INSERT INTO "users" (
+		"email",
+		"username",
+		"password",
+		"verified_at"
+	)
+	VALUES
+		($1, $2, $3, CURRENT_TIMESTAMP)
+	RETURNING "id";`
+
+	if err := tx.QueryRowContext(
+		ctx,
+		query,
+		strings.ToLower(req.Email),
+		strings.ToLower(req.Username),
+		req.Password,
+	).Scan(&identity.UserId); err != nil {
+		tx.Rollback()
+		switch err.Error() {
+		case "ERROR: duplicate key value violates unique constraint \"users_username_key\" (SQLSTATE 23505)":
+			return 0, fmt.Errorf("username has been used")
+		case "ERROR: duplicate key value violates unique constraint \"users_email_key\" (SQLSTATE 23505)":
+			return 0, fmt.Errorf("email has been used")
+		default:
+			return 0, fmt.Errorf("insert user failed: %v", err)
+		}
+	}
+
+	if err := insertUserIdentity(ctx, tx, identity); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit error: %v", err)
+	}
+	return identity.UserId, nil
+}
+
+func insertUserIdentity(ctx context.Context, tx *sqlx.Tx, req *users.UserIdentity) error {
+	query := `
+	INSERT INTO "user_identities" (
+		"user_id",
+		"provider",
+		"subject",
+		"email",
+		"last_login_at"
+	)
+	VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP);`
+
+	if _, err := tx.ExecContext(
+		ctx,
+		query,
+		req.UserId,
+		req.Provider,
+		req.Subject,
+		req.Email,
+	); err != nil {
+		if strings.Contains(err.Error(), "user_identities_provider_subject_idx") {
+			return fmt.Errorf("identity has been linked")
+		}
+		return fmt.Errorf("insert user identity failed: %v", err)
+	}
+	return nil
+}
+
+func (r *usersRepository) InsertInvite(req *users.InviteReq, codeHash string) (*users.Invite, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `
+	INSERT INTO "invites" (
+		"code_hash",
+		"created_by",
+		"max_uses",
+		"expires_at"
+	)
+	VALUES ($1, $2, $3, CASE WHEN $4 > 0 THEN CURRENT_TIMESTAMP + make_interval(secs => $4) END)
+	RETURNING
+		"id",
+		"max_uses",
+		"uses",
+		"expires_at",
+		"createdat";`
+
+	invite := new(users.Invite)
+	if err := r.db.QueryRowxContext(
+		ctx,
+		query,
+		codeHash,
+		req.UserId,
+		req.MaxUses,
+		req.ExpiresIn,
+	).StructScan(invite); err != nil {
+		return nil, fmt.Errorf("insert invite failed: %v", err)
+	}
+	return invite, nil
+}
+
+func (r *usersRepository) FindInvites(userId int) ([]*users.Invite, error) {
+	query := `
+	SELECT
+		"id",
+		"max_uses",
+		"uses",
+		"expires_at",
+		"createdat"
+	FROM "invites"
+	WHERE "created_by" = $1
+	ORDER BY "createdat" DESC;`
+
+	invites := make([]*users.Invite, 0)
+	if err := r.db.Select(&invites, query, userId); err != nil {
+		return nil, fmt.Errorf("get invites failed: %v", err)
+	}
+	return invites, nil
+}
+
+func (r *usersRepository) DeleteInvite(userId int, inviteId string, canManage bool) error {
+	query := `
+	DELETE FROM "invites"
+	WHERE "id" = $1 AND ("created_by" = $2 OR $3);`
+
+	result, err := r.db.Exec(query, inviteId, userId, canManage)
+	if err != nil {
+		return fmt.Errorf("delete invite failed: %v", err)
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting number of affected rows failed: %v", err)
+	}
+	if rowAffected == 0 {
+		return fmt.Errorf("invite not found")
+	}
+	return nil
+}
+
+// UseInvite takes one use of the invite, the update only matches while a use
+// is left so concurrent sign ups cannot overspend it.
+func (r *usersRepository) UseInvite(codeHash string) (string, error) {
+	query := `
+	UPDATE "invites" SET
+		"uses" = "uses" + 1
+	WHERE "code_hash" = $1
+	AND "uses" < "max_uses"
+	AND ("expires_at" IS NULL OR "expires_at" > CURRENT_TIMESTAMP)
+	RETURNING "id";`
+
+	var inviteId string
+	if err := r.db.Get(&inviteId, query, codeHash); err != nil {
+		return "", fmt.Errorf("invite is invalid or expired")
+	}
+	return inviteId, nil
+}
+
+// ReleaseInvite gives back the use taken by a sign up that failed.
+func (r *usersRepository) ReleaseInvite(inviteId string) error {
+	query := `
+	UPDATE "invites" SET
+		"uses" = "uses" - 1
+	WHERE "id" = $1 AND "uses" > 0;`
+
+	if _, err := r.db.Exec(query, inviteId); err != nil {
+		return fmt.Errorf("release invite failed: %v", err)
+	}
+	return nil
+}
+
+func (r *usersRepository) FindUserPassword(userId int) (string, error) {
+	query := `
+	SELECT
+		"password"
+	FROM "users"
+	WHERE "id" = $1;`
+
+	var password string
+	if err := r.db.Get(&password, query, userId); err != nil {
+		return "", fmt.Errorf("user not found")
+	}
+	return password, nil
+}
+
+func (r *usersRepository) FindUserExport(userId int) (*users.UserExport, error) {
+	query := `
+	SELECT
+		jsonb_build_object(
+			'profile', (
+				SELECT
+					jsonb_build_object(
+						'username', "u"."username",
+						'email', "u"."email",
+						'image', "u"."image",
+						'bio', "u"."bio",
+						'role', "u"."role",
+						'verifiedAt', "u"."verified_at",
+						'createdAt', "u"."createdat",
+						'updatedAt', "u"."updatedat"
+					)
+				FROM "users" "u"
+				WHERE "u"."id" = $1
+			),
+			'articles', COALESCE((
+				SELECT
+					jsonb_agg(
+						jsonb_build_object(
+							'slug', "a"."slug",
+							'title', "a"."title",
+							'description', "a"."description",
+							'body', "a"."body",
+							'tagList', COALESCE((
+								SELECT
+									jsonb_agg("t"."name" ORDER BY "t"."name")
+								FROM "article_tags" "at"
+								JOIN "tags" "t" ON "t"."id" = "at"."tag_id"
+								WHERE "at"."article_id" = "a"."id"
+							), '[]'::jsonb),
+							'createdAt', "a"."createdat",
+							'updatedAt', "a"."updatedat"
+						) ORDER BY "a"."createdat"
+					)
+				FROM "articles" "a"
+				WHERE "a"."author_id" = $1
+			), '[]'::jsonb),
+			'comments', COALESCE((
+				SELECT
+					jsonb_agg(
+						jsonb_build_object(
+							'id', "c"."id",
+							'article', "a"."slug",
+							'body', "c"."body",
+							'createdAt', "c"."createdat",
+							'updatedAt', "c"."updatedat"
+						) ORDER BY "c"."createdat"
+					)
+				FROM "comments" "c"
+				JOIN "articles" "a" ON "a"."id" = "c"."article_id"
+				WHERE "c"."author_id" = $1
+			), '[]'::jsonb),
+			'favorites', COALESCE((
+				SELECT
+					jsonb_agg("a"."slug" ORDER BY "a"."slug")
+				FROM "article_favorites" "f"
+				JOIN "articles" "a" ON "a"."id" = "f"."article_id"
+				WHERE "f"."user_id" = $1
+			), '[]'::jsonb),
+			'following', COALESCE((
+				SELECT
+					jsonb_agg("u"."username" ORDER BY "u"."username")
+				FROM "user_follows" "f"
+				JOIN "users" "u" ON "u"."id" = "f"."following_id"
+				WHERE "f"."follower_id" = $1
+			), '[]'::jsonb)
+		);`
+
+	data := make([]byte, 0)
+	if err := r.db.Get(&data, query, userId); err != nil {
+		return nil, fmt.Errorf("get user export failed: %v", err)
+	}
+
+	export := new(users.UserExport)
+	if err := json.Unmarshal(data, export); err != nil {
+		return nil, fmt.Errorf("unmarshal user export failed: %v", err)
+	}
+	if export.Profile == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	return export, nil
+}
+
+// ScheduleUserDeletion also ends every session, logging in again is how the
+// user cancels the deletion.
+func (r *usersRepository) ScheduleUserDeletion(userId int, gracePeriod int) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("begin transaction failed: %v", err)
+	}
+
+	query := `
+	UPDATE "users" SET
+		"deletion_scheduled_at" = CURRENT_TIMESTAMP + make_interval(secs => $2)
+	WHERE "id" = $1
+	RETURNING "deletion_scheduled_at";`
+
+	var scheduledAt time.Time
+	if err := tx.QueryRowxContext(ctx, query, userId, gracePeriod).Scan(&scheduledAt); err != nil {
+		tx.Rollback()
+		return time.Time{}, fmt.Errorf("user not found")
+	}
+
+	oauthQuery := `
+	DELETE FROM "oauth" WHERE "user_id" = $1;`
+
+	if _, err := tx.ExecContext(ctx, oauthQuery, userId); err != nil {
+		tx.Rollback()
+		return time.Time{}, fmt.Errorf("delete oauth failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, fmt.Errorf("commit error: %v", err)
+	}
+	return scheduledAt, nil
+}
+
+func (r *usersRepository) CancelUserDeletion(userId int) (bool, error) {
+	query := `
+	UPDATE "users" SET
+		"deletion_scheduled_at" = NULL
+	WHERE "id" = $1 AND "deletion_scheduled_at" IS NOT NULL;`
+
+	result, err := r.db.Exec(query, userId)
+	if err != nil {
+		return false, fmt.Errorf("cancel user deletion failed: %v", err)
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("getting number of affected rows failed: %v", err)
+	}
+	return rowAffected > 0, nil
+}
+
+// PurgeDeletedUsers removes the users whose grace period is over. With
+// anonymize the articles and comments stay, credited to a scrubbed user row,
+// everything else about the user is deleted. The rows are locked with SKIP
+// LOCKED so several instances can run the job together.
+func (r *usersRepository) PurgeDeletedUsers(anonymize bool) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction failed: %v", err)
+	}
+
+	query := `
+	SELECT
+		"id"
+	FROM "users"
+	WHERE "deletion_scheduled_at" <= CURRENT_TIMESTAMP
+	ORDER BY "deletion_scheduled_at"
+	LIMIT 100
+	FOR UPDATE SKIP LOCKED;`
+
+	userIds := make([]int, 0)
+	if err := tx.SelectContext(ctx, &userIds, query); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("get deleted users failed: %v", err)
+	}
+	if len(userIds) == 0 {
+		tx.Rollback()
+		return 0, nil
+	}
+
+	queries := []string{`
+	DELETE FROM "users" WHERE "id" = ANY($1);`,
+	}
+	if anonymize {
+		queries = []string{
+			`DELETE FROM "oauth" WHERE "user_id" = ANY($1);`,
+			`DELETE FROM "api_keys" WHERE "user_id" = ANY($1);`,
+			`DELETE FROM "user_tokens" WHERE "user_id" = ANY($1);`,
+			`DELETE FROM "recovery_codes" WHERE "user_id" = ANY($1);`,
+			`DELETE FROM "user_identities" WHERE "user_id" = ANY($1);`,
+			`DELETE FROM "invites" WHERE "created_by" = ANY($1);`,
+			`DELETE FROM "article_favorites" WHERE "user_id" = ANY($1);`,
+			`DELETE FROM "user_follows" WHERE "follower_id" = ANY($1) OR "following_id" = ANY($1);`,
+			`DELETE FROM "login_events" WHERE "user_id" = ANY($1);`,
+			`
+	UPDATE "users" SET
+		"email" = NULL,
+		"username" = 'deleted-' || "id",
+		"password" = '!',
+		"image" = NULL,
+		"bio" = NULL,
+		"role" = 'user',
+		"verified_at" = NULL,
+		"totp_secret" = NULL,
+		"totp_enabled_at" = NULL,
+		"deletion_scheduled_at" = NULL,
+		"deleted_at" = CURRENT_TIMESTAMP
+	WHERE "id" = ANY($1);`,
+		}
+	}
+
+	for _, q := range queries {
+		if _, err := tx.ExecContext(ctx, q, userIds); err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("purge deleted users failed: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit error: %v", err)
+	}
+	return len(userIds), nil
+}
+
+// ChangePassword ends every session but the one making the change.
+func (r *usersRepository) ChangePassword(userId int, password string, keepAccessToken string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+
+	query := `
+	UPDATE "users" SET
+		"password" = $1
+	WHERE "id" = $2;`
+
+	if _, err := tx.ExecContext(ctx, query, password, userId); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("update password failed: %v", err)
+	}
+
+	oauthQuery := `
+	DELETE FROM "oauth" WHERE "user_id" = $1 AND "access_token_hash" <> $2;`
+
+	if _, err := tx.ExecContext(ctx, oauthQuery, userId, utils.HashToken(keepAccessToken)); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("delete oauth failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit error: %v", err)
+	}
+	return nil
+}
+
+// ChangeEmail applies the address carried by a confirmed email change token,
+// the new address is verified by the confirmation itself.
+func (r *usersRepository) ChangeEmail(token *users.UserActionToken) error {
+	if token.Payload == nil {
+		return fmt.Errorf("token is invalid or expired")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+
+	if err := consumeUserToken(ctx, tx, token); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	query := `
+	UPDATE "users" SET
+		"email" = $1,
+		"verified_at" = CURRENT_TIMESTAMP
+	WHERE "id" = $2
+	AND NOT EXISTS (
+		SELECT 1
+		FROM "users"
+		WHERE lower("email") = $1 AND "id" <> $2
+	);`
+
+	result, err := tx.ExecContext(ctx, query, strings.ToLower(*token.Payload), token.UserId)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("update email failed: %v", err)
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("getting number of affected rows failed: %v", err)
+	}
+	if rowAffected == 0 {
+		tx.Rollback()
+		return fmt.Errorf("email has been used")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit error: %v", err)
+	}
+	return nil
+}
+
+func (r *usersRepository) FindOneUserByUsername(username string) (*users.User, error) {
+	query := `
+	SELECT
+		"id",
+		"email",
+		"username",
+		"image",
+		"bio",
+		"verified_at",
+		"role"
+	FROM "users"
+	WHERE "username" = $1 AND "deleted_at" IS NULL;`
+
+	user := new(users.User)
+	if err := r.db.Get(user, query, username); err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	return user, nil
+}
+
+// InsertImpersonation stores the session without a refresh token, so it
+// cannot outlive its access token, and records who opened it.
+func (r *usersRepository) InsertImpersonation(req *users.UserToken, impersonatorId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+
+	query := `
+	INSERT INTO "oauth" (
+		"user_id",
+		"access_token_hash",
+		"access_jti",
+		"access_expires_at",
+		"impersonator_id",
+		"ip",
+		"user_agent",
+		"last_used_at"
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP)
+		RETURNING "id";`
+
+	if err := tx.QueryRowContext(
+		ctx,
+		query,
+		req.User_Id,
+		utils.HashToken(req.AccessToken),
+		req.AccessJti,
+		req.AccessExpiresAt,
+		impersonatorId,
+		req.Ip,
+		req.UserAgent,
+	).Scan(&req.Id); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("insert oauth failed: %v", err)
+	}
+
+	auditQuery := `
+	INSERT INTO "audit_logs" (
+		"actor_id",
+		"user_id",
+		"action",
+		"ip"
+	)
+	VALUES ($1, $2, 'impersonation started', $3);`
+
+	if _, err := tx.ExecContext(ctx, auditQuery, impersonatorId, req.User_Id, req.Ip); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("insert audit log failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit error: %v", err)
+	}
+	return nil
+}
+
+func (r *usersRepository) InsertLoginEvent(req *users.LoginEvent) error {
+	query := `
+	INSERT INTO "login_events" (
+		"user_id",
+		"ip",
+		"user_agent",
+		"device_fingerprint",
+		"outcome",
+		"failure_reason"
+	)
+	VALUES (NULLIF($1, 0), $2, $3, $4, $5, $6);`
+
+	if _, err := r.db.ExecContext(
+		context.Background(),
+		query,
+		req.UserId,
+		req.Ip,
+		req.UserAgent,
+		req.DeviceFingerprint,
+		req.Outcome,
+		req.FailureReason,
+	); err != nil {
+		return fmt.Errorf("insert login event failed: %v", err)
+	}
+	return nil
+}
+
+func (r *usersRepository) FindLoginHistory(userId int, limit int) ([]*users.LoginEvent, error) {
+	query := `
+	SELECT
+		"id",
+		"user_id",
+		"ip",
+		"user_agent",
+		"device_fingerprint",
+		"outcome",
+		"failure_reason",
+		"createdat"
+	FROM "login_events"
+	WHERE "user_id" = $1
+	ORDER BY "createdat" DESC, "id" DESC
+	LIMIT $2;`
+
+	events := make([]*users.LoginEvent, 0)
+	if err := r.db.Select(&events, query, userId, limit); err != nil {
+		return nil, fmt.Errorf("get login history failed: %v", err)
+	}
+	return events, nil
+}
+
+// IsNewLoginDevice is false for the very first login of the user, there is
+// no device to tell it apart from yet.
+func (r *usersRepository) IsNewLoginDevice(userId int, deviceFingerprint string) (bool, error) {
+	query := `
+	SELECT
+		EXISTS (
+			SELECT 1
+			FROM "login_events"
+			WHERE "user_id" = $1 AND "outcome" = 'success'
+		)
+		AND NOT EXISTS (
+			SELECT 1
+			FROM "login_events"
+			WHERE "user_id" = $1 AND "outcome" = 'success' AND "device_fingerprint" = $2
+		);`
+
+	var isNew bool
+	if err := r.db.Get(&isNew, query, userId, deviceFingerprint); err != nil {
+		return false, fmt.Errorf("find login device failed: %v", err)
+	}
+	return isNew, nil
+}
@@ -1,91 +1,354 @@
 package usersusecases
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/NattpkJsw/real-world-api-go/config"
+	"github.com/NattpkJsw/real-world-api-go/modules/entities"
+	"github.com/NattpkJsw/real-world-api-go/modules/middlewares"
 	"github.com/NattpkJsw/real-world-api-go/modules/users"
 	usersRepositories "github.com/NattpkJsw/real-world-api-go/modules/users/usersRepositories"
 	"github.com/NattpkJsw/real-world-api-go/pkg/auth"
+	"github.com/NattpkJsw/real-world-api-go/pkg/mailer"
+	"github.com/NattpkJsw/real-world-api-go/pkg/notifier"
+	"github.com/NattpkJsw/real-world-api-go/pkg/oidc"
+	"github.com/NattpkJsw/real-world-api-go/pkg/passwords"
+	"github.com/NattpkJsw/real-world-api-go/pkg/throttle"
+	"github.com/NattpkJsw/real-world-api-go/pkg/tokencache"
+	"github.com/NattpkJsw/real-world-api-go/pkg/totp"
+	"github.com/NattpkJsw/real-world-api-go/pkg/utils"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type IUsersUsecase interface {
-	InsertCustomer(req *users.UserRegisterReq) (*users.ResponsePassport, error)
-	GetPassport(req *users.UserCredential) (*users.ResponsePassport, error)
+	InsertCustomer(req *users.UserRegisterReq, device *users.UserDevice) (*users.ResponsePassport, error)
+	GetPassport(req *users.UserCredential, device *users.UserDevice) (*users.ResponsePassport, *users.TwoFactorChallenge, error)
+	LoginTwoFactor(req *users.TwoFactorLogin, device *users.UserDevice) (*users.ResponsePassport, error)
 	DeleteOauth(accessToken string) error
 	GetUser(token string) (*users.ResponsePassport, error)
 	UpdateUser(user *users.UserCredentialCheck) (*users.ResponsePassport, error)
+	RefreshPassport(refreshToken string) (*users.ResponsePassport, error)
+	GetSessions(userId int, accessToken string) (*users.JSONSessions, error)
+	DeleteSession(userId int, oauthId string) error
+	DeleteSessions(userId int) error
+	CreateApiKey(req *users.ApiKeyReq) (*users.JSONApiKey, error)
+	GetApiKeys(userId int) (*users.JSONApiKeys, error)
+	DeleteApiKey(userId int, apiKeyId string) error
+	ForgotPassword(email string) error
+	ResetPassword(req *users.PasswordReset) error
+	VerifyEmail(token string) error
+	ResendVerification(userId int) error
+	UpdateUserRole(username string, role users.Role) (*users.UserProfile, error)
+	SetupTwoFactor(userId int) (*users.JSONTwoFactorSetup, error)
+	ConfirmTwoFactor(userId int, code string) (*users.RecoveryCodes, error)
+	DisableTwoFactor(userId int, code string) error
+	RegenerateRecoveryCodes(userId int, code string) (*users.RecoveryCodes, error)
+	OidcAuthorize(provider string) (*users.JSONOidcAuthorization, error)
+	OidcLogin(provider string, req *users.OidcCallback, device *users.UserDevice) (*users.ResponsePassport, *users.TwoFactorChallenge, error)
+	SendMagicLink(email string) error
+	LoginMagicLink(token string, device *users.UserDevice) (*users.ResponsePassport, *users.TwoFactorChallenge, error)
+	CreateInvite(req *users.InviteReq, role users.Role) (*users.JSONInvite, error)
+	GetInvites(userId int) (*users.JSONInvites, error)
+	DeleteInvite(userId int, inviteId string, role users.Role) error
+	ExportUser(userId int) (*users.UserExport, error)
+	ExportUserZip(userId int) ([]byte, error)
+	DeleteUser(userId int, password string) (*users.JSONUserDeletion, error)
+	PurgeDeletedUsers(ctx context.Context) error
+	ChangePassword(userId int, accessToken string, req *users.PasswordChange) error
+	RequestEmailChange(userId int, req *users.EmailChange) error
+	ConfirmEmailChange(token string) error
+	Impersonate(impersonatorId int, username string, device *users.UserDevice) (*users.ResponsePassport, error)
+	GetLoginHistory(userId int) (*users.JSONLoginHistory, error)
 }
 
 type usersUsecase struct {
 	cfg             config.IConfig
 	usersRepository usersRepositories.IUsersRepository
+	mailer          mailer.IMailer
+	accountThrottle throttle.IThrottle
+	ipThrottle      throttle.IThrottle
+	magicThrottle   throttle.IThrottle
+	tokenCache      tokencache.ICache
+	oidcClients     map[string]oidc.IClient
+	passwords       passwords.IChecker
+	notifier        notifier.INotifier
+	now             func() time.Time
 }
 
-func UsersUsecase(cfg config.IConfig, usersRepository usersRepositories.IUsersRepository) IUsersUsecase {
+func UsersUsecase(cfg config.IConfig, usersRepository usersRepositories.IUsersRepository, mailer mailer.IMailer, loginStore throttle.IStore, tokenCache tokencache.ICache, oidcClients map[string]oidc.IClient, passwordChecker passwords.IChecker, notify notifier.INotifier) IUsersUsecase {
 	return &usersUsecase{
 		cfg:             cfg,
 		usersRepository: usersRepository,
+		mailer:          mailer,
+		tokenCache:      tokenCache,
+		oidcClients:     oidcClients,
+		passwords:       passwordChecker,
+		notifier:        notify,
+		accountThrottle: throttle.NewThrottle(loginStore, &throttle.Policy{
+			MaxAttempts:     cfg.Users().LoginMaxAttempts(),
+			LockoutDuration: cfg.Users().LoginLockoutDuration(),
+			BackoffAfter:    cfg.Users().LoginBackoffAfter(),
+			BackoffBase:     cfg.Users().LoginBackoffBase(),
+			Window:          cfg.Users().LoginAttemptWindow(),
+		}),
+		ipThrottle: throttle.NewThrottle(loginStore, &throttle.Policy{
+			MaxAttempts:     cfg.Users().LoginIpMaxAttempts(),
+			LockoutDuration: cfg.Users().LoginLockoutDuration(),
+			BackoffAfter:    cfg.Users().LoginIpMaxAttempts(),
+			BackoffBase:     cfg.Users().LoginBackoffBase(),
+			Window:          cfg.Users().LoginAttemptWindow(),
+		}),
+		magicThrottle: throttle.NewThrottle(loginStore, &throttle.Policy{
+			MaxAttempts:     cfg.Users().MagicLinkMaxRequests(),
+			LockoutDuration: cfg.Users().MagicLinkWindow(),
+			BackoffAfter:    cfg.Users().MagicLinkMaxRequests(),
+			BackoffBase:     cfg.Users().LoginBackoffBase(),
+			Window:          cfg.Users().MagicLinkWindow(),
+		}),
+		now: time.Now,
 	}
 }
 
-func (u *usersUsecase) InsertCustomer(req *users.UserRegisterReq) (*users.ResponsePassport, error) {
+// validatePassword returns the broken policy rules as a validation error of
+// the given request field.
+func (u *usersUsecase) validatePassword(field, password string, userInputs ...string) error {
+	if problems := u.passwords.Check(password, userInputs...); len(problems) > 0 {
+		err := entities.NewValidationError()
+		err.Add(field, problems...)
+		return err
+	}
+	return nil
+}
+
+func (u *usersUsecase) InsertCustomer(req *users.UserRegisterReq, device *users.UserDevice) (*users.ResponsePassport, error) {
+	// The mode goes first so a closed instance spends no breached password
+	// lookup or hash on a sign-up it refuses anyway
+	mode := u.cfg.Users().RegistrationMode()
+	switch mode {
+	case "closed":
+		return nil, fmt.Errorf("registration is closed")
+	case "invite":
+		if strings.TrimSpace(req.InviteCode) == "" {
+			return nil, fmt.Errorf("invite code is required")
+		}
+	}
+
+	if err := u.validatePassword("password", req.Password, req.Username, req.Email); err != nil {
+		return nil, err
+	}
 	password := req.Password
 	if err := req.BcryptHashing(); err != nil {
 		return nil, err
 	}
 
+	var inviteId string
+	if mode == "invite" {
+		// The invite is only spent once the password is accepted
+		id, err := u.usersRepository.UseInvite(utils.HashToken(strings.TrimSpace(req.InviteCode)))
+		if err != nil {
+			return nil, err
+		}
+		inviteId = id
+	}
+
 	// Insert user
-	_, err := u.usersRepository.InsertUser(req)
+	user, err := u.usersRepository.InsertUser(req)
 	if err != nil {
+		if inviteId != "" {
+			if err := u.usersRepository.ReleaseInvite(inviteId); err != nil {
+				log.Printf("release invite failed: %v", err)
+			}
+		}
 		return nil, err
 	}
+	if err := u.sendVerification(user); err != nil {
+		log.Printf("send verification mail failed: %v", err)
+	}
 
 	loginUser := &users.UserCredential{
 		Email:    req.Email,
 		Password: password,
 	}
 
-	return u.GetPassport(loginUser)
+	// A new account has no second factor yet
+	passport, _, err := u.GetPassport(loginUser, device)
+	return passport, err
 
 }
 
-func (u *usersUsecase) GetPassport(req *users.UserCredential) (*users.ResponsePassport, error) {
+// GetPassport checks the password. Accounts with two-factor authentication
+// get a challenge instead of a passport, see LoginTwoFactor.
+func (u *usersUsecase) GetPassport(req *users.UserCredential, device *users.UserDevice) (*users.ResponsePassport, *users.TwoFactorChallenge, error) {
+	accountKey := "email:" + strings.ToLower(req.Email)
+	ipKey := "ip:" + device.Ip
+	if wait := u.loginWait(accountKey, ipKey); wait > 0 {
+		err := &users.LoginLockedError{RetryAfter: wait}
+		u.recordLogin(0, device, err)
+		return nil, nil, err
+	}
+
 	//Find user
 	user, err := u.usersRepository.FindOneUserByEmail(req.Email)
 	if err != nil {
-		return nil, err
+		u.loginFailed(accountKey, ipKey)
+		u.recordLogin(0, device, err)
+		return nil, nil, err
 	}
 	//Compare password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		return nil, fmt.Errorf("password is invalid")
+		u.loginFailed(accountKey, ipKey)
+		err = fmt.Errorf("password is invalid")
+		u.recordLogin(user.Id, device, err)
+		return nil, nil, err
+	}
+	if err := u.accountThrottle.Reset(accountKey); err != nil {
+		log.Printf("reset login throttle failed: %v", err)
+	}
+
+	return u.loginUser(&users.User{
+		Id:       user.Id,
+		Email:    user.Email,
+		Username: user.Username,
+		Image:    user.Image,
+		Bio:      user.Bio,
+		Role:     user.Role,
+	}, user.TotpEnabled, device)
+}
+
+// loginUser finishes a login whose first factor passed, users with
+// two-factor authentication get a challenge instead of a passport.
+func (u *usersUsecase) loginUser(user *users.User, totpEnabled bool, device *users.UserDevice) (*users.ResponsePassport, *users.TwoFactorChallenge, error) {
+	if totpEnabled {
+		challenge, err := auth.NewAuth(auth.Challenge, u.cfg.Jwt(), &users.UserClaims{
+			Id:   user.Id,
+			Role: user.Role,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, &users.TwoFactorChallenge{
+			ChallengeToken: challenge.SignToken(),
+			ExpiresIn:      u.cfg.Jwt().ChallengeExpiresAt(),
+		}, nil
+	}
+
+	passport, err := u.issuePassport(user, device)
+	if err != nil {
+		return nil, nil, err
+	}
+	return passport, nil, nil
+}
+
+// deviceFingerprint tells the devices of a user apart by the user agent, the
+// ip is left out as it changes all the time on mobile networks.
+func deviceFingerprint(device *users.UserDevice) string {
+	return utils.HashToken(device.UserAgent)
+}
+
+// recordLogin keeps the login in the history of the user, failures of an
+// unknown email are kept without a user. It never fails the login.
+func (u *usersUsecase) recordLogin(userId int, device *users.UserDevice, failure error) {
+	event := &users.LoginEvent{
+		UserId:            userId,
+		Ip:                &device.Ip,
+		UserAgent:         &device.UserAgent,
+		DeviceFingerprint: deviceFingerprint(device),
+		Outcome:           users.LoginSucceeded,
+	}
+	if failure != nil {
+		reason := failure.Error()
+		event.Outcome = users.LoginFailed
+		event.FailureReason = &reason
+	}
+	if err := u.usersRepository.InsertLoginEvent(event); err != nil {
+		log.Printf("record login failed: %v", err)
+	}
+}
+
+// alertNewDevice must run before the login is recorded, or every device
+// would look known.
+func (u *usersUsecase) alertNewDevice(user *users.User, device *users.UserDevice) {
+	isNew, err := u.usersRepository.IsNewLoginDevice(user.Id, deviceFingerprint(device))
+	if err != nil {
+		log.Printf("find login device failed: %v", err)
+		return
+	}
+	if !isNew {
+		return
 	}
+
+	if err := u.notifier.Notify(&notifier.Notification{
+		Event:   notifier.NewDevice,
+		UserId:  user.Id,
+		Email:   user.Email,
+		Subject: "New sign-in to your account",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nYour account was just signed in to from a new device.\n\nDevice: %s\nIP address: %s\nTime: %s\n\nIf this was you, there is nothing to do. If not, change your password and end the session right away.",
+			user.Username,
+			device.UserAgent,
+			device.Ip,
+			u.now().UTC().Format(time.RFC1123),
+		),
+	}); err != nil {
+		log.Printf("send new device notification failed: %v", err)
+	}
+}
+
+// issuePassport starts a new session for a user who passed every login step.
+func (u *usersUsecase) issuePassport(user *users.User, device *users.UserDevice) (*users.ResponsePassport, error) {
 	// sign token
-	accessToken, err := auth.NewAuth(auth.Access, u.cfg.Jwt(), &users.UserClaims{
-		Id: user.Id,
-	})
+	claims := &users.UserClaims{
+		Id:   user.Id,
+		Role: user.Role,
+	}
+	accessToken, err := auth.NewAuth(auth.Access, u.cfg.Jwt(), claims)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := auth.NewAuth(auth.Refresh, u.cfg.Jwt(), claims)
 	if err != nil {
 		return nil, err
 	}
 
 	// set user token
 	userToken := &users.UserToken{
-		User_Id:     user.Id,
-		AccessToken: accessToken.SignToken(),
+		User_Id:         user.Id,
+		AccessToken:     accessToken.SignToken(),
+		AccessJti:       accessToken.Id(),
+		AccessExpiresAt: accessToken.ExpiresAt(),
+		RefreshToken:    refreshToken.SignToken(),
+		Ip:              device.Ip,
+		UserAgent:       device.UserAgent,
 	}
 	if err := u.usersRepository.InsertOauth(userToken); err != nil {
 		return nil, err
 	}
+	u.alertNewDevice(user, device)
+	u.recordLogin(user.Id, device, nil)
+	// Logging in is how a pending account deletion gets cancelled
+	if cancelled, err := u.usersRepository.CancelUserDeletion(user.Id); err != nil {
+		log.Printf("cancel user deletion failed: %v", err)
+	} else if cancelled {
+		log.Printf("user %d logged in, account deletion cancelled", user.Id)
+	}
 
 	//Set passport
 	passport := &users.UserPassport{
 		// Id:       user.Id,
-		Email:    user.Email,
-		Username: user.Username,
-		Image:    user.Image,
-		Bio:      user.Bio,
-		Token:    userToken.AccessToken,
+		Email:        user.Email,
+		Username:     user.Username,
+		Image:        user.Image,
+		Bio:          user.Bio,
+		Token:        userToken.AccessToken,
+		RefreshToken: userToken.RefreshToken,
 	}
 	passportOutput := &users.ResponsePassport{
 		User: *passport,
@@ -93,10 +356,42 @@ func (u *usersUsecase) GetPassport(req *users.UserCredential) (*users.ResponsePa
 	return passportOutput, nil
 }
 
+// loginWait returns the longest wait among the throttled keys. A broken
+// throttle store must not lock everyone out, so its errors only get logged.
+func (u *usersUsecase) loginWait(accountKey, ipKey string) time.Duration {
+	accountWait, err := u.accountThrottle.Check(accountKey)
+	if err != nil {
+		log.Printf("check login throttle failed: %v", err)
+	}
+	ipWait, err := u.ipThrottle.Check(ipKey)
+	if err != nil {
+		log.Printf("check login throttle failed: %v", err)
+	}
+	if ipWait > accountWait {
+		return ipWait
+	}
+	return accountWait
+}
+
+func (u *usersUsecase) loginFailed(accountKey, ipKey string) {
+	if _, err := u.accountThrottle.Fail(accountKey); err != nil {
+		log.Printf("record failed login failed: %v", err)
+	}
+	if _, err := u.ipThrottle.Fail(ipKey); err != nil {
+		log.Printf("record failed login failed: %v", err)
+	}
+}
+
 func (u *usersUsecase) DeleteOauth(accessToken string) error {
 	if err := u.usersRepository.DeleteOauth(accessToken); err != nil {
 		return err
 	}
+	u.tokenCache.Delete(utils.HashToken(accessToken))
+	// The database trigger revokes it for every instance, this one should
+	// not wait for the notification
+	if claims, err := auth.ParseToken(u.cfg.Jwt(), accessToken); err == nil && claims.ID != "" {
+		u.tokenCache.Revoke(claims.ID, claims.ExpiresAt.Time)
+	}
 	return nil
 }
 
@@ -132,7 +427,23 @@ func (u *usersUsecase) GetUser(token string) (*users.ResponsePassport, error) {
 	return resPassport, nil
 }
 
+// UpdateUser changes the profile only. The password and the email need the
+// current password, see ChangePassword and RequestEmailChange.
 func (u *usersUsecase) UpdateUser(user *users.UserCredentialCheck) (*users.ResponsePassport, error) {
+	if user.Password != "" {
+		return nil, fmt.Errorf("password cannot be changed here")
+	}
+	if user.Email != "" {
+		current, err := u.usersRepository.GetProfile(user.Id)
+		if err != nil {
+			return nil, err
+		}
+		// Clients often send the whole user back, an unchanged email is fine
+		if !strings.EqualFold(strings.TrimSpace(user.Email), current.Email) {
+			return nil, fmt.Errorf("email cannot be changed here")
+		}
+	}
+
 	updatedUser, err := u.usersRepository.UpdateUser(user)
 	if err != nil {
 		return nil, err
@@ -162,21 +473,1065 @@ func (u *usersUsecase) refreshToken(accessTokenIn string) (*users.UserToken, err
 		return nil, err
 	}
 
-	accessToken, err := auth.NewAuth(auth.Access, u.cfg.Jwt(), &users.UserClaims{
-		Id: oauthID.UserId,
-	})
-	if err != nil {
-		return nil, err
+	claims := &users.UserClaims{
+		Id:   oauthID.UserId,
+		Role: oauthID.Role,
+	}
+	var accessToken auth.IAuth
+	if oauthID.ImpersonatorId != 0 {
+		// An impersonation stays one, with the admin and the short expiry
+		if oauthID.AccessExpiresAt == nil {
+			return nil, fmt.Errorf("impersonation has expired")
+		}
+		claims.ImpersonatorId = oauthID.ImpersonatorId
+		accessToken = auth.RepeatImpersonation(u.cfg.Jwt(), claims, oauthID.AccessExpiresAt.Unix())
+	} else {
+		accessToken, err = auth.NewAuth(auth.Access, u.cfg.Jwt(), claims)
+		if err != nil {
+			return nil, err
+		}
 	}
 	passport := &users.UserToken{
-		Id:          oauthID.Id,
-		User_Id:     oauthID.UserId,
-		AccessToken: accessToken.SignToken(),
+		Id:              oauthID.Id,
+		User_Id:         oauthID.UserId,
+		AccessToken:     accessToken.SignToken(),
+		AccessJti:       accessToken.Id(),
+		AccessExpiresAt: accessToken.ExpiresAt(),
 	}
 
 	if err := u.usersRepository.UpdateOauth(passport); err != nil {
 		return nil, err
 	}
+	u.tokenCache.DeleteUser(oauthID.UserId)
 
 	return passport, nil
 }
+
+// RefreshPassport exchanges a refresh token for a new token pair. The refresh
+// token keeps its original expiry, so a session cannot be extended forever.
+// Presenting a refresh token that was already rotated revokes the session.
+func (u *usersUsecase) RefreshPassport(refreshTokenIn string) (*users.ResponsePassport, error) {
+	claims, err := auth.ParseRefreshToken(u.cfg.Jwt(), refreshTokenIn)
+	if err != nil {
+		return nil, err
+	}
+
+	oauth, err := u.usersRepository.FindOneOauthByRefreshToken(refreshTokenIn)
+	if err != nil {
+		if oauthId, err := u.usersRepository.FindOauthIdByUsedRefreshToken(refreshTokenIn); err == nil {
+			if err := u.usersRepository.DeleteOauthById(oauthId); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("refresh token has been reused")
+		}
+		return nil, fmt.Errorf("refresh token is invalid")
+	}
+	if claims.Claims == nil || claims.Claims.Id != oauth.UserId {
+		return nil, fmt.Errorf("refresh token is invalid")
+	}
+
+	profile, err := u.usersRepository.GetProfile(oauth.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	userClaims := &users.UserClaims{
+		Id:   oauth.UserId,
+		Role: oauth.Role,
+	}
+	accessToken, err := auth.NewAuth(auth.Access, u.cfg.Jwt(), userClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	userToken := &users.UserToken{
+		Id:              oauth.Id,
+		User_Id:         oauth.UserId,
+		AccessToken:     accessToken.SignToken(),
+		AccessJti:       accessToken.Id(),
+		AccessExpiresAt: accessToken.ExpiresAt(),
+		RefreshToken:    auth.RepeatToken(u.cfg.Jwt(), userClaims, claims.ExpiresAt.Unix()),
+	}
+	err = u.usersRepository.RotateOauth(userToken, refreshTokenIn)
+	// The old access token of the session is dead either way
+	u.tokenCache.DeleteUser(oauth.UserId)
+	if err != nil {
+		// Another request rotated this token first, treat it as a reuse
+		if err.Error() == "refresh token has been used" {
+			if err := u.usersRepository.DeleteOauthById(oauth.Id); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+
+	passport := &users.UserPassport{
+		Email:        profile.Email,
+		Username:     profile.Username,
+		Image:        profile.Image,
+		Bio:          profile.Bio,
+		Token:        userToken.AccessToken,
+		RefreshToken: userToken.RefreshToken,
+	}
+	return &users.ResponsePassport{
+		User: *passport,
+	}, nil
+}
+
+func (u *usersUsecase) GetSessions(userId int, accessToken string) (*users.JSONSessions, error) {
+	sessions, err := u.usersRepository.FindSessions(userId, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	return &users.JSONSessions{
+		Sessions: sessions,
+	}, nil
+}
+
+// GetLoginHistory answers the latest logins only, older events are kept for
+// the new device check.
+func (u *usersUsecase) GetLoginHistory(userId int) (*users.JSONLoginHistory, error) {
+	events, err := u.usersRepository.FindLoginHistory(userId, 50)
+	if err != nil {
+		return nil, err
+	}
+	return &users.JSONLoginHistory{
+		LoginHistory: events,
+	}, nil
+}
+
+func (u *usersUsecase) DeleteSession(userId int, oauthId string) error {
+	if err := u.usersRepository.DeleteSession(userId, oauthId); err != nil {
+		return err
+	}
+	u.tokenCache.DeleteUser(userId)
+	return nil
+}
+
+func (u *usersUsecase) DeleteSessions(userId int) error {
+	if err := u.usersRepository.DeleteSessions(userId); err != nil {
+		return err
+	}
+	u.tokenCache.DeleteUser(userId)
+	return nil
+}
+
+func (u *usersUsecase) CreateApiKey(req *users.ApiKeyReq) (*users.JSONApiKey, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return nil, fmt.Errorf("api key name is required")
+	}
+	switch req.Scope {
+	case "":
+		req.Scope = string(middlewares.ReadLevel)
+	case string(middlewares.ReadLevel), string(middlewares.WriteLevel):
+	default:
+		return nil, fmt.Errorf("api key scope is invalid")
+	}
+
+	apiKey, err := u.usersRepository.InsertApiKey(req)
+	if err != nil {
+		return nil, err
+	}
+	apiKey.Key = auth.NewApiKey(u.cfg.Jwt(), &users.UserClaims{
+		Id: req.UserId,
+	}, apiKey.Id).SignToken()
+
+	return &users.JSONApiKey{
+		ApiKey: apiKey,
+	}, nil
+}
+
+func (u *usersUsecase) GetApiKeys(userId int) (*users.JSONApiKeys, error) {
+	apiKeys, err := u.usersRepository.FindApiKeys(userId)
+	if err != nil {
+		return nil, err
+	}
+	return &users.JSONApiKeys{
+		ApiKeys: apiKeys,
+	}, nil
+}
+
+func (u *usersUsecase) DeleteApiKey(userId int, apiKeyId string) error {
+	return u.usersRepository.DeleteApiKey(userId, apiKeyId)
+}
+
+// newUserToken stores the hash of a fresh single-use token and returns the
+// token itself, which is only ever sent to the user.
+func (u *usersUsecase) newUserToken(userId int, purpose users.TokenPurpose, payload *string, expiresIn int) (string, error) {
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return "", fmt.Errorf("generate token failed: %v", err)
+	}
+
+	userToken := &users.UserActionToken{
+		UserId:    userId,
+		Purpose:   purpose,
+		TokenHash: utils.HashToken(token),
+		Payload:   payload,
+	}
+	if err := u.usersRepository.InsertUserToken(userToken, expiresIn); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// clientLink points to the frontend page for the token, or is just the token
+// when no client url is configured.
+func (u *usersUsecase) clientLink(path, token string) string {
+	if u.cfg.App().ClientUrl() == "" {
+		return token
+	}
+	return fmt.Sprintf("%s%s?token=%s", u.cfg.App().ClientUrl(), path, url.QueryEscape(token))
+}
+
+func (u *usersUsecase) ForgotPassword(email string) error {
+	user, err := u.usersRepository.FindOneUserByEmail(email)
+	if err != nil {
+		// Do not tell whether the email is registered
+		return nil
+	}
+
+	expiresIn := u.cfg.Users().PasswordResetExpiresAt()
+	token, err := u.newUserToken(user.Id, users.PasswordResetPurpose, nil, expiresIn)
+	if err != nil {
+		return err
+	}
+
+	msg := &mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nWe received a request to reset your password. Use the link below within %d minutes to choose a new one:\n\n%s\n\nIf you did not ask for this, you can ignore this email.",
+			user.Username,
+			expiresIn/60,
+			u.clientLink("/reset-password", token),
+		),
+	}
+	if err := u.mailer.Send(msg); err != nil {
+		log.Printf("send password reset mail failed: %v", err)
+	}
+	return nil
+}
+
+func (u *usersUsecase) ResetPassword(req *users.PasswordReset) error {
+	if req.Password == "" {
+		return fmt.Errorf("password is required")
+	}
+
+	token, err := u.usersRepository.FindUserToken(users.PasswordResetPurpose, utils.HashToken(req.Token))
+	if err != nil {
+		return err
+	}
+	profile, err := u.usersRepository.GetProfile(token.UserId)
+	if err != nil {
+		return err
+	}
+	if err := u.validatePassword("password", req.Password, profile.Username, profile.Email); err != nil {
+		return err
+	}
+
+	user := &users.UserCredentialCheck{
+		Id:       token.UserId,
+		Password: req.Password,
+	}
+	if err := user.BcryptHashingUpdate(); err != nil {
+		return err
+	}
+	if err := u.usersRepository.ResetPassword(token, user.Password); err != nil {
+		return err
+	}
+	u.tokenCache.DeleteUser(token.UserId)
+	return nil
+}
+
+func (u *usersUsecase) sendVerification(user *users.User) error {
+	expiresIn := u.cfg.Users().VerificationExpiresAt()
+	token, err := u.newUserToken(user.Id, users.EmailVerifyPurpose, nil, expiresIn)
+	if err != nil {
+		return err
+	}
+
+	return u.mailer.Send(&mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease confirm your email address with the link below within %d hours:\n\n%s\n\nIf you did not sign up, you can ignore this email.",
+			user.Username,
+			expiresIn/3600,
+			u.clientLink("/verify-email", token),
+		),
+	})
+}
+
+func (u *usersUsecase) VerifyEmail(token string) error {
+	userToken, err := u.usersRepository.FindUserToken(users.EmailVerifyPurpose, utils.HashToken(token))
+	if err != nil {
+		return err
+	}
+	return u.usersRepository.VerifyEmail(userToken)
+}
+
+func (u *usersUsecase) ResendVerification(userId int) error {
+	user, err := u.usersRepository.GetProfile(userId)
+	if err != nil {
+		return err
+	}
+	if user.VerifiedAt != nil {
+		return fmt.Errorf("email is already verified")
+	}
+	return u.sendVerification(user)
+}
+
+func (u *usersUsecase) UpdateUserRole(username string, role users.Role) (*users.UserProfile, error) {
+	if !role.IsValid() {
+		return nil, fmt.Errorf("role is invalid")
+	}
+
+	user, err := u.usersRepository.UpdateUserRole(username, role)
+	if err != nil {
+		return nil, err
+	}
+	u.tokenCache.DeleteUser(user.Id)
+	return &users.UserProfile{
+		Profile: &users.Profile{
+			Username: user.Username,
+			Image:    user.Image,
+			Bio:      user.Bio,
+		},
+	}, nil
+}
+
+func (u *usersUsecase) LoginTwoFactor(req *users.TwoFactorLogin, device *users.UserDevice) (*users.ResponsePassport, error) {
+	claims, err := auth.ParseChallengeToken(u.cfg.Jwt(), req.ChallengeToken)
+	if err != nil {
+		return nil, fmt.Errorf("challenge token is invalid")
+	}
+	userId := claims.Claims.Id
+
+	// The challenge token is the proof of the password, the code guessing
+	// behind it is throttled like the password itself.
+	if err := u.throttledSecondFactor(userId, req.Code); err != nil {
+		u.recordLogin(userId, device, err)
+		return nil, err
+	}
+
+	user, err := u.usersRepository.GetProfile(userId)
+	if err != nil {
+		return nil, err
+	}
+	return u.issuePassport(user, device)
+}
+
+// throttledSecondFactor is verifySecondFactor behind the account throttle,
+// every path taking a code shares the key so the guesses add up.
+func (u *usersUsecase) throttledSecondFactor(userId int, code string) error {
+	key := fmt.Sprintf("2fa:%d", userId)
+	wait, err := u.accountThrottle.Check(key)
+	if err != nil {
+		log.Printf("check login throttle failed: %v", err)
+	}
+	if wait > 0 {
+		return &users.LoginLockedError{RetryAfter: wait}
+	}
+
+	if err := u.verifySecondFactor(userId, code); err != nil {
+		if err.Error() == "code is invalid" {
+			if _, err := u.accountThrottle.Fail(key); err != nil {
+				log.Printf("record failed login failed: %v", err)
+			}
+		}
+		return err
+	}
+	if err := u.accountThrottle.Reset(key); err != nil {
+		log.Printf("reset login throttle failed: %v", err)
+	}
+	return nil
+}
+
+// verifySecondFactor accepts a totp code or one of the recovery codes, both
+// can only be used once.
+func (u *usersUsecase) verifySecondFactor(userId int, code string) error {
+	userTotp, err := u.usersRepository.FindUserTotp(userId)
+	if err != nil {
+		return err
+	}
+	if userTotp.EnabledAt == nil || userTotp.Secret == nil {
+		return fmt.Errorf("two-factor authentication is not enabled")
+	}
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return fmt.Errorf("code is invalid")
+	}
+	if step, ok := totp.Validate(*userTotp.Secret, code, u.now(), userTotp.LastStep); ok {
+		return u.usersRepository.UseTotpStep(userId, step)
+	}
+	return u.usersRepository.UseRecoveryCode(userId, utils.HashToken(normalizeRecoveryCode(code)))
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// newRecoveryCodes returns the codes to show to the user once and the hashes
+// to store.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, 10)
+	hashes := make([]string, 0, 10)
+	for i := 0; i < 10; i++ {
+		code, err := utils.RandomToken(5)
+		if err != nil {
+			return nil, nil, fmt.Errorf("generate recovery code failed: %v", err)
+		}
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, utils.HashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+func (u *usersUsecase) SetupTwoFactor(userId int) (*users.JSONTwoFactorSetup, error) {
+	user, err := u.usersRepository.GetProfile(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("generate totp secret failed: %v", err)
+	}
+	if err := u.usersRepository.SetTotpSecret(userId, secret); err != nil {
+		return nil, err
+	}
+
+	return &users.JSONTwoFactorSetup{
+		TwoFactor: &users.TwoFactorSetup{
+			Secret: secret,
+			Uri:    totp.ProvisioningURI(secret, u.cfg.App().Name(), user.Email),
+		},
+	}, nil
+}
+
+func (u *usersUsecase) ConfirmTwoFactor(userId int, code string) (*users.RecoveryCodes, error) {
+	userTotp, err := u.usersRepository.FindUserTotp(userId)
+	if err != nil {
+		return nil, err
+	}
+	if userTotp.EnabledAt != nil {
+		return nil, fmt.Errorf("two-factor authentication is already enabled")
+	}
+	if userTotp.Secret == nil {
+		return nil, fmt.Errorf("two-factor authentication is not set up")
+	}
+
+	step, ok := totp.Validate(*userTotp.Secret, strings.TrimSpace(code), u.now(), userTotp.LastStep)
+	if !ok {
+		return nil, fmt.Errorf("code is invalid")
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := u.usersRepository.EnableTotp(userId, step, hashes); err != nil {
+		return nil, err
+	}
+	return &users.RecoveryCodes{
+		RecoveryCodes: codes,
+	}, nil
+}
+
+func (u *usersUsecase) DisableTwoFactor(userId int, code string) error {
+	if err := u.throttledSecondFactor(userId, code); err != nil {
+		return err
+	}
+	return u.usersRepository.DisableTotp(userId)
+}
+
+func (u *usersUsecase) RegenerateRecoveryCodes(userId int, code string) (*users.RecoveryCodes, error) {
+	if err := u.throttledSecondFactor(userId, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := u.usersRepository.ReplaceRecoveryCodes(userId, hashes); err != nil {
+		return nil, err
+	}
+	return &users.RecoveryCodes{
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// OidcAuthorize starts an OpenID Connect login. The state, nonce and PKCE
+// verifier stay on the server until the provider redirects back.
+func (u *usersUsecase) OidcAuthorize(provider string) (*users.JSONOidcAuthorization, error) {
+	client, ok := u.oidcClients[provider]
+	if !ok {
+		return nil, fmt.Errorf("provider not found")
+	}
+
+	state, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("generate state failed: %v", err)
+	}
+	nonce, err := utils.RandomToken(16)
+	if err != nil {
+		return nil, fmt.Errorf("generate nonce failed: %v", err)
+	}
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		return nil, fmt.Errorf("generate code verifier failed: %v", err)
+	}
+
+	authorizationUrl, err := client.AuthCodeURL(state, nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		return nil, err
+	}
+	if err := u.usersRepository.InsertOidcState(&users.OidcState{
+		StateHash:    utils.HashToken(state),
+		Provider:     provider,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+	}, u.cfg.Oidc().StateExpiresAt()); err != nil {
+		return nil, err
+	}
+
+	return &users.JSONOidcAuthorization{
+		Oidc: &users.OidcAuthorization{
+			AuthorizationUrl: authorizationUrl,
+			State:            state,
+		},
+	}, nil
+}
+
+// OidcLogin finishes an OpenID Connect login. The identity is looked up
+// first, then an existing user with the same verified email gets it linked,
+// otherwise a new user is created.
+func (u *usersUsecase) OidcLogin(provider string, req *users.OidcCallback, device *users.UserDevice) (*users.ResponsePassport, *users.TwoFactorChallenge, error) {
+	client, ok := u.oidcClients[provider]
+	if !ok {
+		return nil, nil, fmt.Errorf("provider not found")
+	}
+
+	state, err := u.usersRepository.ConsumeOidcState(provider, utils.HashToken(req.State))
+	if err != nil {
+		return nil, nil, err
+	}
+	rawIdToken, err := client.Exchange(req.Code, state.CodeVerifier)
+	if err != nil {
+		return nil, nil, err
+	}
+	claims, err := client.VerifyIdToken(rawIdToken, state.Nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	userId, err := u.oidcUserId(provider, claims)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := u.usersRepository.GetProfile(userId)
+	if err != nil {
+		return nil, nil, err
+	}
+	userTotp, err := u.usersRepository.FindUserTotp(userId)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u.loginUser(user, userTotp.EnabledAt != nil, device)
+}
+
+func (u *usersUsecase) oidcUserId(provider string, claims *oidc.Claims) (int, error) {
+	if userId, err := u.usersRepository.FindUserIdentity(provider, claims.Subject); err == nil {
+		return userId, nil
+	}
+
+	// An unverified email could belong to anyone, it must not take over an
+	// account or block the address for its owner
+	if claims.Email == "" || !claims.EmailVerified {
+		return 0, fmt.Errorf("email is not verified by provider")
+	}
+	identity := &users.UserIdentity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    strings.ToLower(claims.Email),
+	}
+
+	if user, err := u.usersRepository.FindOneUserByEmail(claims.Email); err == nil {
+		// Anyone could have registered the address without owning it, the
+		// owner has to confirm it before the provider is trusted with it
+		if user.VerifiedAt == nil {
+			return 0, fmt.Errorf("email must be verified before linking")
+		}
+		identity.UserId = user.Id
+		if err := u.usersRepository.LinkUserIdentity(identity); err != nil {
+			return 0, err
+		}
+		return user.Id, nil
+	}
+
+	// An invite code cannot come through the provider callback
+	if u.cfg.Users().RegistrationMode() != "open" {
+		return 0, fmt.Errorf("registration is closed")
+	}
+
+	// The password is random and never shown, the user can set one through
+	// the forgot password flow
+	password, err := utils.RandomToken(32)
+	if err != nil {
+		return 0, fmt.Errorf("generate password failed: %v", err)
+	}
+	req := &users.UserRegisterReq{
+		Email:    claims.Email,
+		Password: password,
+	}
+	if err := req.BcryptHashing(); err != nil {
+		return 0, err
+	}
+
+	base := oidcUsername(claims)
+	for i := 0; i < 5; i++ {
+		req.Username = base
+		if i > 0 {
+			suffix, err := utils.RandomToken(2)
+			if err != nil {
+				return 0, fmt.Errorf("generate username failed: %v", err)
+			}
+			req.Username = base + "-" + suffix
+		}
+		userId, err := u.usersRepository.InsertOidcUser(req, identity)
+		if err == nil {
+			return userId, nil
+		}
+		if err.Error() != "username has been used" {
+			return 0, err
+		}
+	}
+	return 0, fmt.Errorf("username has been used")
+}
+
+// oidcUsername picks a username from the preferred username or the email,
+// keeping only the characters safe in a profile url.
+func oidcUsername(claims *oidc.Claims) string {
+	name := claims.PreferredUsername
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '-' || r == '.' {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		return "user"
+	}
+	return b.String()
+}
+
+// SendMagicLink emails a single-use login link. Requests are counted per
+// email whether or not it is registered, and over the limit nothing is sent,
+// so the response never tells which emails have an account.
+func (u *usersUsecase) SendMagicLink(email string) error {
+	key := "magic:" + strings.ToLower(strings.TrimSpace(email))
+	wait, err := u.magicThrottle.Check(key)
+	if err != nil {
+		log.Printf("check magic link throttle failed: %v", err)
+	}
+	if wait > 0 {
+		return nil
+	}
+	if _, err := u.magicThrottle.Fail(key); err != nil {
+		log.Printf("record magic link request failed: %v", err)
+	}
+
+	user, err := u.usersRepository.FindOneUserByEmail(email)
+	if err != nil {
+		return nil
+	}
+
+	expiresIn := u.cfg.Users().MagicLinkExpiresAt()
+	token, err := u.newUserToken(user.Id, users.MagicLinkPurpose, nil, expiresIn)
+	if err != nil {
+		return err
+	}
+
+	msg := &mailer.Message{
+		To:      user.Email,
+		Subject: "Your login link",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nUse the link below within %d minutes to log in. It works only once:\n\n%s\n\nIf you did not ask for this, you can ignore this email.",
+			user.Username,
+			expiresIn/60,
+			u.clientLink("/login/magic", token),
+		),
+	}
+	if err := u.mailer.Send(msg); err != nil {
+		log.Printf("send magic link mail failed: %v", err)
+	}
+	return nil
+}
+
+// LoginMagicLink exchanges a magic link token for a passport. Opening the
+// link proves the email address, so it is marked verified as well.
+func (u *usersUsecase) LoginMagicLink(token string, device *users.UserDevice) (*users.ResponsePassport, *users.TwoFactorChallenge, error) {
+	userToken, err := u.usersRepository.FindUserToken(users.MagicLinkPurpose, utils.HashToken(token))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := u.usersRepository.VerifyEmail(userToken); err != nil {
+		return nil, nil, err
+	}
+
+	user, err := u.usersRepository.GetProfile(userToken.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+	userTotp, err := u.usersRepository.FindUserTotp(userToken.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u.loginUser(user, userTotp.EnabledAt != nil, device)
+}
+
+// CreateInvite mints an invite code, only admins can unless invites by users
+// are switched on in the config.
+func (u *usersUsecase) CreateInvite(req *users.InviteReq, role users.Role) (*users.JSONInvite, error) {
+	if !role.Can(users.ManageInvites) && !u.cfg.Users().InvitesByUsers() {
+		return nil, fmt.Errorf("permission denied")
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+	if req.MaxUses < 0 {
+		return nil, fmt.Errorf("invite max uses is invalid")
+	}
+	if req.ExpiresIn < 0 {
+		return nil, fmt.Errorf("invite expires in is invalid")
+	}
+
+	code, err := utils.RandomToken(16)
+	if err != nil {
+		return nil, fmt.Errorf("generate invite code failed: %v", err)
+	}
+	invite, err := u.usersRepository.InsertInvite(req, utils.HashToken(code))
+	if err != nil {
+		return nil, err
+	}
+	invite.Code = code
+
+	return &users.JSONInvite{
+		Invite: invite,
+	}, nil
+}
+
+func (u *usersUsecase) GetInvites(userId int) (*users.JSONInvites, error) {
+	invites, err := u.usersRepository.FindInvites(userId)
+	if err != nil {
+		return nil, err
+	}
+	return &users.JSONInvites{
+		Invites: invites,
+	}, nil
+}
+
+func (u *usersUsecase) DeleteInvite(userId int, inviteId string, role users.Role) error {
+	return u.usersRepository.DeleteInvite(userId, inviteId, role.Can(users.ManageInvites))
+}
+
+func (u *usersUsecase) ExportUser(userId int) (*users.UserExport, error) {
+	export, err := u.usersRepository.FindUserExport(userId)
+	if err != nil {
+		return nil, err
+	}
+	export.ExportedAt = u.now().UTC().Format(time.RFC3339)
+	return export, nil
+}
+
+// ExportUserZip is the same export with one json file per section.
+func (u *usersUsecase) ExportUserZip(userId int) ([]byte, error) {
+	export, err := u.ExportUser(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{name: "profile.json", data: export.Profile},
+		{name: "articles.json", data: export.Articles},
+		{name: "comments.json", data: export.Comments},
+		{name: "favorites.json", data: export.Favorites},
+		{name: "following.json", data: export.Following},
+	}
+
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, file := range files {
+		f, err := w.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: u.now(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create export file failed: %v", err)
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return nil, fmt.Errorf("write export file failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("close export zip failed: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DeleteUser schedules the account for deletion once the grace period is
+// over, see PurgeDeletedUsers.
+func (u *usersUsecase) DeleteUser(userId int, password string) (*users.JSONUserDeletion, error) {
+	if err := u.checkPassword(userId, password); err != nil {
+		return nil, err
+	}
+
+	scheduledAt, err := u.usersRepository.ScheduleUserDeletion(userId, u.cfg.Users().DeletionGracePeriod())
+	if err != nil {
+		return nil, err
+	}
+	u.tokenCache.DeleteUser(userId)
+
+	user, err := u.usersRepository.GetProfile(userId)
+	if err != nil {
+		log.Printf("get user failed: %v", err)
+	} else if err := u.mailer.Send(&mailer.Message{
+		To:      user.Email,
+		Subject: "Your account will be deleted",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nYour account is scheduled for deletion on %s. Until then you can keep it by simply logging in again.\n\nIf you did not ask for this, log in and change your password.",
+			user.Username,
+			scheduledAt.Format("2 January 2006 15:04 MST"),
+		),
+	}); err != nil {
+		log.Printf("send deletion mail failed: %v", err)
+	}
+
+	return &users.JSONUserDeletion{
+		Deletion: &users.UserDeletion{
+			ScheduledAt: scheduledAt,
+		},
+	}, nil
+}
+
+// PurgeDeletedUsers is the background job removing the users whose grace
+// period is over, in batches until none is left.
+func (u *usersUsecase) PurgeDeletedUsers(ctx context.Context) error {
+	anonymize := u.cfg.Users().DeletionContent() == "anonymize"
+	for ctx.Err() == nil {
+		count, err := u.usersRepository.PurgeDeletedUsers(anonymize)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil
+		}
+		log.Printf("purged %d deleted users", count)
+	}
+	return nil
+}
+
+// checkPassword asks for the current password again before a sensitive change.
+func (u *usersUsecase) checkPassword(userId int, password string) error {
+	// A stolen session must not be able to guess the password behind it
+	key := fmt.Sprintf("reauth:%d", userId)
+	wait, err := u.accountThrottle.Check(key)
+	if err != nil {
+		log.Printf("check login throttle failed: %v", err)
+	}
+	if wait > 0 {
+		return &users.LoginLockedError{RetryAfter: wait}
+	}
+
+	hashed, err := u.usersRepository.FindUserPassword(userId)
+	if err != nil {
+		return err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password)); err != nil {
+		if _, err := u.accountThrottle.Fail(key); err != nil {
+			log.Printf("record failed login failed: %v", err)
+		}
+		return fmt.Errorf("password is invalid")
+	}
+	if err := u.accountThrottle.Reset(key); err != nil {
+		log.Printf("reset login throttle failed: %v", err)
+	}
+	return nil
+}
+
+// ChangePassword keeps the session making the change and ends the others.
+// An api key has no session to keep, it would end every one of them.
+func (u *usersUsecase) ChangePassword(userId int, accessToken string, req *users.PasswordChange) error {
+	if accessToken == "" {
+		return fmt.Errorf("session is required")
+	}
+	if req.NewPassword == "" {
+		return fmt.Errorf("new password is required")
+	}
+	if err := u.checkPassword(userId, req.CurrentPassword); err != nil {
+		return err
+	}
+	profile, err := u.usersRepository.GetProfile(userId)
+	if err != nil {
+		return err
+	}
+	if err := u.validatePassword("newPassword", req.NewPassword, profile.Username, profile.Email); err != nil {
+		return err
+	}
+
+	user := &users.UserCredentialCheck{
+		Id:       userId,
+		Password: req.NewPassword,
+	}
+	if err := user.BcryptHashingUpdate(); err != nil {
+		return err
+	}
+	if err := u.usersRepository.ChangePassword(userId, user.Password, accessToken); err != nil {
+		return err
+	}
+	u.tokenCache.DeleteUser(userId)
+
+	if err := u.mailer.Send(&mailer.Message{
+		To:      profile.Email,
+		Subject: "Your password was changed",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nThe password of your account was just changed and your other sessions were logged out.\n\nIf you did not do this, reset your password right away.",
+			profile.Username,
+		),
+	}); err != nil {
+		log.Printf("send password changed mail failed: %v", err)
+	}
+	return nil
+}
+
+// RequestEmailChange sends a confirmation link to the new address, the email
+// only changes once it is opened, see ConfirmEmailChange.
+func (u *usersUsecase) RequestEmailChange(userId int, req *users.EmailChange) error {
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if !(&users.UserRegisterReq{Email: email}).IsEmail() {
+		return fmt.Errorf("email pattern is invalid")
+	}
+	if err := u.checkPassword(userId, req.Password); err != nil {
+		return err
+	}
+
+	profile, err := u.usersRepository.GetProfile(userId)
+	if err != nil {
+		return err
+	}
+	if email == profile.Email {
+		return fmt.Errorf("email is unchanged")
+	}
+	if _, err := u.usersRepository.FindOneUserByEmail(email); err == nil {
+		return fmt.Errorf("email has been used")
+	}
+
+	expiresIn := u.cfg.Users().VerificationExpiresAt()
+	token, err := u.newUserToken(userId, users.EmailChangePurpose, &email, expiresIn)
+	if err != nil {
+		return err
+	}
+
+	return u.mailer.Send(&mailer.Message{
+		To:      email,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease confirm this is your new email address with the link below within %d hours:\n\n%s\n\nIf you did not ask for this, you can ignore this email.",
+			profile.Username,
+			expiresIn/3600,
+			u.clientLink("/confirm-email", token),
+		),
+	})
+}
+
+// ConfirmEmailChange applies the new email and lets the old address know.
+func (u *usersUsecase) ConfirmEmailChange(token string) error {
+	userToken, err := u.usersRepository.FindUserToken(users.EmailChangePurpose, utils.HashToken(token))
+	if err != nil {
+		return err
+	}
+	profile, err := u.usersRepository.GetProfile(userToken.UserId)
+	if err != nil {
+		return err
+	}
+	if err := u.usersRepository.ChangeEmail(userToken); err != nil {
+		return err
+	}
+
+	if err := u.mailer.Send(&mailer.Message{
+		To:      profile.Email,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nThe email address of your account was changed to %s. Emails will no longer be sent to this address.\n\nIf you did not do this, contact us right away.",
+			profile.Username,
+			*userToken.Payload,
+		),
+	}); err != nil {
+		log.Printf("send email changed mail failed: %v", err)
+	}
+	return nil
+}
+
+// Impersonate signs the admin in as the user. Admins cannot be impersonated,
+// that would let one admin act with the session of another.
+func (u *usersUsecase) Impersonate(impersonatorId int, username string, device *users.UserDevice) (*users.ResponsePassport, error) {
+	user, err := u.usersRepository.FindOneUserByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	if user.Id == impersonatorId {
+		return nil, fmt.Errorf("cannot impersonate yourself")
+	}
+	if user.Role == users.AdminRole {
+		return nil, fmt.Errorf("admins cannot be impersonated")
+	}
+
+	claims := &users.UserClaims{
+		Id:             user.Id,
+		Role:           user.Role,
+		ImpersonatorId: impersonatorId,
+	}
+	accessToken, err := auth.NewAuth(auth.Impersonation, u.cfg.Jwt(), claims)
+	if err != nil {
+		return nil, err
+	}
+
+	userToken := &users.UserToken{
+		User_Id:         user.Id,
+		AccessToken:     accessToken.SignToken(),
+		AccessJti:       accessToken.Id(),
+		AccessExpiresAt: accessToken.ExpiresAt(),
+		Ip:              device.Ip,
+		UserAgent:       device.UserAgent,
+	}
+	if err := u.usersRepository.InsertImpersonation(userToken, impersonatorId); err != nil {
+		return nil, err
+	}
+	log.Printf("user %d started impersonating user %d", impersonatorId, user.Id)
+
+	return &users.ResponsePassport{
+		User: users.UserPassport{
+			Email:    user.Email,
+			Username: user.Username,
+			Image:    user.Image,
+			Bio:      user.Bio,
+			Token:    userToken.AccessToken,
+		},
+	}, nil
+}
@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"crypto/ed25519"
+	"crypto/rsa"
 	"errors"
 	"fmt"
 	"math"
@@ -9,18 +11,25 @@ import (
 	"github.com/NattpkJsw/real-world-api-go/config"
 	"github.com/NattpkJsw/real-world-api-go/modules/users"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type TokenType string
 
 const (
-	Access  TokenType = "access"
-	Refresh TokenType = "refresh"
-	ApiKey  TokenType = "apikey"
+	Access    TokenType = "access"
+	Refresh   TokenType = "refresh"
+	ApiKey    TokenType = "apikey"
+	Challenge TokenType = "challenge"
+	// Impersonation is an access token the claims carry the admin in, it is
+	// short lived and has no refresh token
+	Impersonation TokenType = "impersonation"
 )
 
 type IAuth interface {
 	SignToken() string
+	Id() string
+	ExpiresAt() time.Time
 }
 
 type IApiKey interface {
@@ -50,24 +59,63 @@ func jwtTimeRepeatAdapter(t int64) *jwt.NumericDate {
 }
 
 func (a *auth) SignToken() string {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, a.mapClaims)
-	ss, _ := token.SignedString(a.cfg.SecretKey())
+	var key any = a.cfg.SecretKey()
+	method := jwt.GetSigningMethod(a.cfg.SigningMethod())
+	if method != jwt.SigningMethodHS256 {
+		key = a.cfg.SigningKey()
+	}
+	token := jwt.NewWithClaims(method, a.mapClaims)
+	if kid := a.cfg.KeyId(); kid != "" {
+		token.Header["kid"] = kid
+	}
+	ss, _ := token.SignedString(key)
 	return ss
 }
 
+func (a *auth) Id() string { return a.mapClaims.ID }
+
+func (a *auth) ExpiresAt() time.Time { return a.mapClaims.ExpiresAt.Time }
+
 func (a *apiKey) SignToken() string {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, a.mapClaims)
 	ss, _ := token.SignedString(a.cfg.APiKey())
 	return ss
 }
 
-func ParseToken(cfg config.IJwtConfig, tokenString string) (*mapClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &mapClaims{}, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("signing method is invalid")
+// verifyKey picks the key by the kid header. HS256 tokens keep verifying
+// while JWT_SECRET_KEY is set, so moving to asymmetric keys doesn't log
+// everyone out.
+func verifyKey(cfg config.IJwtConfig) jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+			if len(cfg.SecretKey()) == 0 {
+				return nil, fmt.Errorf("signing method is invalid")
+			}
+			return cfg.SecretKey(), nil
 		}
-		return cfg.SecretKey(), nil
-	})
+
+		kid, _ := t.Header["kid"].(string)
+		key := cfg.VerifyKey(kid)
+		switch key.(type) {
+		case *rsa.PublicKey:
+			if t.Method == jwt.SigningMethodRS256 {
+				return key, nil
+			}
+		case ed25519.PublicKey:
+			if t.Method == jwt.SigningMethodEdDSA {
+				return key, nil
+			}
+		case nil:
+			return nil, fmt.Errorf("signing key is unknown")
+		}
+		return nil, fmt.Errorf("signing method is invalid")
+	}
+}
+
+func ParseToken(cfg config.IJwtConfig, tokenString string) (*mapClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &mapClaims{}, verifyKey(cfg),
+		jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}),
+	)
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenMalformed) {
 			return nil, fmt.Errorf("token format is invalid")
@@ -85,6 +133,30 @@ func ParseToken(cfg config.IJwtConfig, tokenString string) (*mapClaims, error) {
 	}
 }
 
+func ParseRefreshToken(cfg config.IJwtConfig, tokenString string) (*mapClaims, error) {
+	claims, err := ParseToken(cfg, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject != "refresh-token" {
+		return nil, fmt.Errorf("token is not a refresh token")
+	}
+	return claims, nil
+}
+
+// ParseChallengeToken accepts only the short lived token handed out by login
+// while the second factor is pending.
+func ParseChallengeToken(cfg config.IJwtConfig, tokenString string) (*mapClaims, error) {
+	claims, err := ParseToken(cfg, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject != "2fa-challenge" {
+		return nil, fmt.Errorf("token is not a challenge token")
+	}
+	return claims, nil
+}
+
 func ParseApiKey(cfg config.IJwtConfig, tokenString string) (*mapClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &mapClaims{}, func(t *jwt.Token) (interface{}, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -117,6 +189,7 @@ func RepeatToken(cfg config.IJwtConfig, claims *users.UserClaims, exp int64) str
 			RegisteredClaims: jwt.RegisteredClaims{
 				Issuer:    "realworld-api",
 				Subject:   "refresh-token",
+				ID:        uuid.NewString(),
 				ExpiresAt: jwtTimeRepeatAdapter(exp),
 				NotBefore: jwt.NewNumericDate(time.Now()),
 				IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -126,6 +199,25 @@ func RepeatToken(cfg config.IJwtConfig, claims *users.UserClaims, exp int64) str
 	return obj.SignToken()
 }
 
+// RepeatImpersonation signs a new impersonation token keeping the expiry of
+// the one it replaces, so refreshing never lengthens an impersonation.
+func RepeatImpersonation(cfg config.IJwtConfig, claims *users.UserClaims, exp int64) IAuth {
+	return &auth{
+		cfg: cfg,
+		mapClaims: &mapClaims{
+			Claims: claims,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    "realworld-api",
+				Subject:   "access-token",
+				ID:        uuid.NewString(),
+				ExpiresAt: jwtTimeRepeatAdapter(exp),
+				NotBefore: jwt.NewNumericDate(time.Now()),
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+			},
+		},
+	}
+}
+
 func NewAuth(tokenType TokenType, cfg config.IJwtConfig, claims *users.UserClaims) (IAuth, error) {
 	switch tokenType {
 	case Access:
@@ -133,7 +225,11 @@ func NewAuth(tokenType TokenType, cfg config.IJwtConfig, claims *users.UserClaim
 	case Refresh:
 		return newRefreshToken(cfg, claims), nil
 	case ApiKey:
-		return newApiKey(cfg), nil
+		return newApiKey(cfg, claims), nil
+	case Challenge:
+		return newChallengeToken(cfg, claims), nil
+	case Impersonation:
+		return newImpersonationToken(cfg, claims), nil
 	default:
 		return nil, fmt.Errorf("unknown token type")
 	}
@@ -147,6 +243,7 @@ func newAccessToken(cfg config.IJwtConfig, claims *users.UserClaims) IAuth {
 			RegisteredClaims: jwt.RegisteredClaims{
 				Issuer:    "realworld-api",
 				Subject:   "access-token",
+				ID:        uuid.NewString(),
 				ExpiresAt: jwtTimeDurationCal(cfg.AccessExpiresAt()),
 				NotBefore: jwt.NewNumericDate(time.Now()),
 				IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -163,6 +260,7 @@ func newRefreshToken(cfg config.IJwtConfig, claims *users.UserClaims) IAuth {
 			RegisteredClaims: jwt.RegisteredClaims{
 				Issuer:    "realworld-api",
 				Subject:   "refresh-token",
+				ID:        uuid.NewString(),
 				ExpiresAt: jwtTimeDurationCal(cfg.RefreshExpiresAt()),
 				NotBefore: jwt.NewNumericDate(time.Now()),
 				IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -171,12 +269,54 @@ func newRefreshToken(cfg config.IJwtConfig, claims *users.UserClaims) IAuth {
 	}
 }
 
-func newApiKey(cfg config.IJwtConfig) IAuth {
+func newChallengeToken(cfg config.IJwtConfig, claims *users.UserClaims) IAuth {
+	return &auth{
+		cfg: cfg,
+		mapClaims: &mapClaims{
+			Claims: claims,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    "realworld-api",
+				Subject:   "2fa-challenge",
+				ID:        uuid.NewString(),
+				ExpiresAt: jwtTimeDurationCal(cfg.ChallengeExpiresAt()),
+				NotBefore: jwt.NewNumericDate(time.Now()),
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+			},
+		},
+	}
+}
+
+func newImpersonationToken(cfg config.IJwtConfig, claims *users.UserClaims) IAuth {
+	return &auth{
+		cfg: cfg,
+		mapClaims: &mapClaims{
+			Claims: claims,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    "realworld-api",
+				Subject:   "access-token",
+				ID:        uuid.NewString(),
+				ExpiresAt: jwtTimeDurationCal(cfg.ImpersonationExpiresAt()),
+				NotBefore: jwt.NewNumericDate(time.Now()),
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+			},
+		},
+	}
+}
+
+// NewApiKey signs an api key for the user, the key id is kept as the token id
+// so the key can be looked up and revoked without storing the key itself.
+func NewApiKey(cfg config.IJwtConfig, claims *users.UserClaims, keyId string) IApiKey {
+	key := newApiKey(cfg, claims)
+	key.mapClaims.ID = keyId
+	return key
+}
+
+func newApiKey(cfg config.IJwtConfig, claims *users.UserClaims) *apiKey {
 	return &apiKey{
 		auth: &auth{
 			cfg: cfg,
 			mapClaims: &mapClaims{
-				Claims: nil,
+				Claims: claims,
 				RegisteredClaims: jwt.RegisteredClaims{
 					Issuer:    "realworld-api",
 					Subject:   "api-key",
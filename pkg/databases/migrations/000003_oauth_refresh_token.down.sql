@@ -0,0 +1,8 @@
+BEGIN;
+
+DROP TABLE IF EXISTS "oauth_refresh_history" CASCADE;
+
+DROP INDEX IF EXISTS "oauth_refresh_token_idx";
+ALTER TABLE "oauth" DROP COLUMN IF EXISTS "refresh_token";
+
+COMMIT;
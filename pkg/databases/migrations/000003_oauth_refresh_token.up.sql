@@ -0,0 +1,15 @@
+BEGIN;
+
+ALTER TABLE "oauth" ADD COLUMN "refresh_token" VARCHAR;
+
+CREATE TABLE "oauth_refresh_history" (
+  "oauth_id" uuid NOT NULL,
+  "refresh_token" VARCHAR NOT NULL UNIQUE,
+  "createdat" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE "oauth_refresh_history" ADD FOREIGN KEY ("oauth_id") REFERENCES "oauth" ("id") ON DELETE CASCADE;
+
+CREATE UNIQUE INDEX "oauth_refresh_token_idx" ON "oauth" ("refresh_token");
+
+COMMIT;
@@ -0,0 +1,11 @@
+BEGIN;
+
+ALTER TABLE "oauth" ADD COLUMN "ip" VARCHAR;
+ALTER TABLE "oauth" ADD COLUMN "user_agent" VARCHAR;
+ALTER TABLE "oauth" ADD COLUMN "last_used_at" TIMESTAMP;
+
+UPDATE "oauth" SET "last_used_at" = "updatedat";
+
+CREATE INDEX "oauth_user_id_idx" ON "oauth" ("user_id");
+
+COMMIT;
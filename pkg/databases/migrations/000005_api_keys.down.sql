@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP TRIGGER IF EXISTS set_updatedat_timestamp_api_keys_table ON "api_keys";
+
+DROP TABLE IF EXISTS "api_keys" CASCADE;
+
+COMMIT;
@@ -0,0 +1,17 @@
+BEGIN;
+
+CREATE TABLE "api_keys" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "user_id" INT NOT NULL,
+  "name" VARCHAR NOT NULL,
+  "scope" VARCHAR NOT NULL DEFAULT 'read' CHECK ("scope" IN ('read', 'write')),
+  "last_used_at" TIMESTAMP,
+  "createdat" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "updatedat" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE "api_keys" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+CREATE TRIGGER set_updatedat_timestamp_api_keys_table BEFORE UPDATE ON "api_keys" FOR EACH ROW EXECUTE PROCEDURE set_updatedat_column();
+
+COMMIT;
@@ -0,0 +1,17 @@
+BEGIN;
+
+CREATE TABLE "user_tokens" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "user_id" INT NOT NULL,
+  "purpose" VARCHAR NOT NULL,
+  "token_hash" VARCHAR NOT NULL UNIQUE,
+  "expires_at" TIMESTAMP NOT NULL,
+  "used_at" TIMESTAMP,
+  "createdat" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE "user_tokens" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+CREATE INDEX "user_tokens_user_id_purpose_idx" ON "user_tokens" ("user_id", "purpose");
+
+COMMIT;
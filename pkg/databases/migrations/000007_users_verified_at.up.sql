@@ -0,0 +1,8 @@
+BEGIN;
+
+ALTER TABLE "users" ADD COLUMN "verified_at" TIMESTAMP;
+
+-- Accounts created before verification existed are trusted as they are
+UPDATE "users" SET "verified_at" = "createdat";
+
+COMMIT;
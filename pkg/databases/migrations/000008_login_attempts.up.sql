@@ -0,0 +1,13 @@
+BEGIN;
+
+CREATE TABLE "login_attempts" (
+  "key" VARCHAR PRIMARY KEY,
+  "failures" INT NOT NULL DEFAULT 0,
+  "last_failure_at" TIMESTAMPTZ NOT NULL,
+  "locked_until" TIMESTAMPTZ NOT NULL,
+  "expires_at" TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX "login_attempts_expires_at_idx" ON "login_attempts" ("expires_at");
+
+COMMIT;
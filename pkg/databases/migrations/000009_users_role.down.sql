@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE "users" DROP CONSTRAINT IF EXISTS "users_role_check";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "role";
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE "users" ADD COLUMN "role" VARCHAR NOT NULL DEFAULT 'user';
+ALTER TABLE "users" ADD CONSTRAINT "users_role_check" CHECK ("role" IN ('user', 'moderator', 'admin'));
+
+COMMIT;
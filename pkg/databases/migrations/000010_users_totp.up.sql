@@ -0,0 +1,20 @@
+BEGIN;
+
+ALTER TABLE "users" ADD COLUMN "totp_secret" VARCHAR;
+ALTER TABLE "users" ADD COLUMN "totp_enabled_at" TIMESTAMP;
+-- Last accepted time step, a code is never accepted twice
+ALTER TABLE "users" ADD COLUMN "totp_last_step" BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE "recovery_codes" (
+  "id" SERIAL PRIMARY KEY,
+  "user_id" INT NOT NULL,
+  "code_hash" VARCHAR NOT NULL,
+  "used_at" TIMESTAMP,
+  "createdat" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE "recovery_codes" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+CREATE UNIQUE INDEX "recovery_codes_user_id_code_hash_idx" ON "recovery_codes" ("user_id", "code_hash");
+
+COMMIT;
@@ -0,0 +1,13 @@
+BEGIN;
+
+-- The raw tokens cannot be recovered from the hashes, every session ends
+DELETE FROM "oauth";
+
+DROP INDEX IF EXISTS "oauth_access_token_hash_idx";
+
+ALTER INDEX "oauth_refresh_token_hash_idx" RENAME TO "oauth_refresh_token_idx";
+ALTER TABLE "oauth_refresh_history" RENAME COLUMN "refresh_token_hash" TO "refresh_token";
+ALTER TABLE "oauth" RENAME COLUMN "refresh_token_hash" TO "refresh_token";
+ALTER TABLE "oauth" RENAME COLUMN "access_token_hash" TO "access_token";
+
+COMMIT;
@@ -0,0 +1,18 @@
+BEGIN;
+
+ALTER TABLE "oauth" RENAME COLUMN "access_token" TO "access_token_hash";
+ALTER TABLE "oauth" RENAME COLUMN "refresh_token" TO "refresh_token_hash";
+ALTER TABLE "oauth_refresh_history" RENAME COLUMN "refresh_token" TO "refresh_token_hash";
+ALTER INDEX "oauth_refresh_token_idx" RENAME TO "oauth_refresh_token_hash_idx";
+
+-- Existing rows hold the raw tokens, hash them in place so nobody is logged out
+UPDATE "oauth" SET
+  "access_token_hash" = encode(sha256(convert_to("access_token_hash", 'UTF8')), 'hex'),
+  "refresh_token_hash" = encode(sha256(convert_to("refresh_token_hash", 'UTF8')), 'hex');
+
+UPDATE "oauth_refresh_history" SET
+  "refresh_token_hash" = encode(sha256(convert_to("refresh_token_hash", 'UTF8')), 'hex');
+
+CREATE INDEX "oauth_access_token_hash_idx" ON "oauth" ("access_token_hash");
+
+COMMIT;
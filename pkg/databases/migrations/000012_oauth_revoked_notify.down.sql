@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TRIGGER IF EXISTS notify_oauth_revoked_oauth_table ON "oauth";
+DROP FUNCTION IF EXISTS notify_oauth_revoked();
+
+COMMIT;
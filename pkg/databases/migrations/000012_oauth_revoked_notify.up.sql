@@ -0,0 +1,17 @@
+BEGIN;
+
+-- Tells every api instance to drop a session from its token cache
+CREATE OR REPLACE FUNCTION notify_oauth_revoked()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND OLD."access_token_hash" IS NOT DISTINCT FROM NEW."access_token_hash" THEN
+        RETURN NULL;
+    END IF;
+    PERFORM pg_notify('oauth_revoked', OLD."access_token_hash");
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER notify_oauth_revoked_oauth_table AFTER DELETE OR UPDATE OF "access_token_hash" ON "oauth" FOR EACH ROW EXECUTE PROCEDURE notify_oauth_revoked();
+
+COMMIT;
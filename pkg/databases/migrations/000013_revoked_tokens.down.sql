@@ -0,0 +1,19 @@
+BEGIN;
+
+CREATE OR REPLACE FUNCTION notify_oauth_revoked()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND OLD."access_token_hash" IS NOT DISTINCT FROM NEW."access_token_hash" THEN
+        RETURN NULL;
+    END IF;
+    PERFORM pg_notify('oauth_revoked', OLD."access_token_hash");
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+DROP TABLE IF EXISTS "revoked_tokens";
+
+ALTER TABLE "oauth" DROP COLUMN IF EXISTS "access_expires_at";
+ALTER TABLE "oauth" DROP COLUMN IF EXISTS "access_jti";
+
+COMMIT;
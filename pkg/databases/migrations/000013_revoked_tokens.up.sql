@@ -0,0 +1,35 @@
+BEGIN;
+
+ALTER TABLE "oauth" ADD COLUMN "access_jti" VARCHAR;
+ALTER TABLE "oauth" ADD COLUMN "access_expires_at" TIMESTAMPTZ;
+
+-- Denylist for stateless validation, a row is only useful until the token expires
+CREATE TABLE "revoked_tokens" (
+  "jti" VARCHAR PRIMARY KEY,
+  "expires_at" TIMESTAMPTZ NOT NULL,
+  "createdat" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX "revoked_tokens_expires_at_idx" ON "revoked_tokens" ("expires_at");
+
+-- Every way a session ends also revokes its access token
+CREATE OR REPLACE FUNCTION notify_oauth_revoked()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND OLD."access_token_hash" IS NOT DISTINCT FROM NEW."access_token_hash" THEN
+        RETURN NULL;
+    END IF;
+    PERFORM pg_notify('oauth_revoked', OLD."access_token_hash");
+
+    IF OLD."access_jti" IS NOT NULL AND OLD."access_expires_at" > CURRENT_TIMESTAMP THEN
+        DELETE FROM "revoked_tokens" WHERE "expires_at" <= CURRENT_TIMESTAMP;
+        INSERT INTO "revoked_tokens" ("jti", "expires_at")
+        VALUES (OLD."access_jti", OLD."access_expires_at")
+        ON CONFLICT ("jti") DO NOTHING;
+        PERFORM pg_notify('token_revoked', OLD."access_jti" || ':' || floor(extract(epoch FROM OLD."access_expires_at"))::BIGINT);
+    END IF;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+COMMIT;
@@ -0,0 +1,28 @@
+BEGIN;
+
+-- Pending logins, the state is kept hashed and can only be used once
+CREATE TABLE "oidc_states" (
+  "state_hash" VARCHAR PRIMARY KEY,
+  "provider" VARCHAR NOT NULL,
+  "nonce" VARCHAR NOT NULL,
+  "code_verifier" VARCHAR NOT NULL,
+  "expires_at" TIMESTAMP NOT NULL,
+  "createdat" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE "user_identities" (
+  "id" SERIAL PRIMARY KEY,
+  "user_id" INT NOT NULL,
+  "provider" VARCHAR NOT NULL,
+  "subject" VARCHAR NOT NULL,
+  "email" VARCHAR,
+  "last_login_at" TIMESTAMP,
+  "createdat" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE "user_identities" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+CREATE UNIQUE INDEX "user_identities_provider_subject_idx" ON "user_identities" ("provider", "subject");
+CREATE INDEX "user_identities_user_id_idx" ON "user_identities" ("user_id");
+
+COMMIT;
@@ -0,0 +1,18 @@
+BEGIN;
+
+CREATE TABLE "invites" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "code_hash" VARCHAR NOT NULL UNIQUE,
+  "created_by" INT NOT NULL,
+  "max_uses" INT NOT NULL DEFAULT 1 CHECK ("max_uses" > 0),
+  "uses" INT NOT NULL DEFAULT 0,
+  "expires_at" TIMESTAMP,
+  "createdat" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CHECK ("uses" BETWEEN 0 AND "max_uses")
+);
+
+ALTER TABLE "invites" ADD FOREIGN KEY ("created_by") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+CREATE INDEX "invites_created_by_idx" ON "invites" ("created_by");
+
+COMMIT;
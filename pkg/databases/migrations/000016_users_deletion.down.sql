@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP INDEX IF EXISTS "users_deletion_scheduled_at_idx";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "deleted_at";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "deletion_scheduled_at";
+
+COMMIT;
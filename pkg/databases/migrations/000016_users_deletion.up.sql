@@ -0,0 +1,10 @@
+BEGIN;
+
+-- Set while a deletion request waits out its grace period, a login clears it
+ALTER TABLE "users" ADD COLUMN "deletion_scheduled_at" TIMESTAMP;
+-- Set on the row kept as the author of anonymized content
+ALTER TABLE "users" ADD COLUMN "deleted_at" TIMESTAMP;
+
+CREATE INDEX "users_deletion_scheduled_at_idx" ON "users" ("deletion_scheduled_at") WHERE "deletion_scheduled_at" IS NOT NULL;
+
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+
+ALTER TABLE "user_tokens" DROP COLUMN IF EXISTS "payload";
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+-- Data the token carries, e.g. the new address of an email change
+ALTER TABLE "user_tokens" ADD COLUMN "payload" VARCHAR;
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TABLE IF EXISTS "audit_logs";
+ALTER TABLE "oauth" DROP COLUMN IF EXISTS "impersonator_id";
+
+COMMIT;
@@ -0,0 +1,22 @@
+BEGIN;
+
+-- Set on the sessions an admin opened as another user
+ALTER TABLE "oauth" ADD COLUMN "impersonator_id" INT;
+
+ALTER TABLE "oauth" ADD FOREIGN KEY ("impersonator_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+-- No foreign keys, the trail outlives the accounts it mentions
+CREATE TABLE "audit_logs" (
+  "id" BIGSERIAL PRIMARY KEY,
+  "actor_id" INT NOT NULL,
+  "user_id" INT NOT NULL,
+  "action" VARCHAR NOT NULL,
+  "status" INT,
+  "ip" VARCHAR,
+  "createdat" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX "audit_logs_actor_id_idx" ON "audit_logs" ("actor_id");
+CREATE INDEX "audit_logs_user_id_idx" ON "audit_logs" ("user_id");
+
+COMMIT;
@@ -0,0 +1,20 @@
+BEGIN;
+
+-- A failed login of an unknown email is kept without a user
+CREATE TABLE "login_events" (
+  "id" BIGSERIAL PRIMARY KEY,
+  "user_id" INT,
+  "ip" VARCHAR,
+  "user_agent" VARCHAR,
+  "device_fingerprint" VARCHAR NOT NULL,
+  "outcome" VARCHAR NOT NULL CHECK ("outcome" IN ('success', 'failure')),
+  "failure_reason" VARCHAR,
+  "createdat" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE "login_events" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+CREATE INDEX "login_events_user_id_createdat_idx" ON "login_events" ("user_id", "createdat" DESC);
+CREATE INDEX "login_events_user_id_device_fingerprint_idx" ON "login_events" ("user_id", "device_fingerprint") WHERE "outcome" = 'success';
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+
+DROP INDEX IF EXISTS "articles_author_id_status_idx";
+DROP INDEX IF EXISTS "articles_publish_at_idx";
+ALTER TABLE "articles" DROP COLUMN IF EXISTS "publish_at";
+ALTER TABLE "articles" DROP COLUMN IF EXISTS "status";
+
+COMMIT;
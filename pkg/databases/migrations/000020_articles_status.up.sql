@@ -0,0 +1,15 @@
+BEGIN;
+
+-- Existing articles were public as soon as they were inserted
+ALTER TABLE "articles" ADD COLUMN "status" VARCHAR NOT NULL DEFAULT 'published'
+  CHECK ("status" IN ('draft', 'scheduled', 'published', 'archived'));
+ALTER TABLE "articles" ADD COLUMN "publish_at" TIMESTAMP;
+
+UPDATE "articles" SET "publish_at" = "createdat";
+
+ALTER TABLE "articles" ADD CHECK ("status" <> 'scheduled' OR "publish_at" IS NOT NULL);
+
+CREATE INDEX "articles_publish_at_idx" ON "articles" ("publish_at") WHERE "status" = 'scheduled';
+CREATE INDEX "articles_author_id_status_idx" ON "articles" ("author_id", "status");
+
+COMMIT;
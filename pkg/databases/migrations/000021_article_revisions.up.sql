@@ -0,0 +1,23 @@
+BEGIN;
+
+-- Rows are only ever inserted, a restore is written as a new revision
+CREATE TABLE "article_revisions" (
+  "article_id" INT NOT NULL,
+  "revision" INT NOT NULL,
+  "title" VARCHAR NOT NULL,
+  "description" VARCHAR,
+  "body" TEXT,
+  "editor_id" INT,
+  "createdat" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY ("article_id", "revision")
+);
+
+ALTER TABLE "article_revisions" ADD FOREIGN KEY ("article_id") REFERENCES "articles" ("id") ON DELETE CASCADE;
+ALTER TABLE "article_revisions" ADD FOREIGN KEY ("editor_id") REFERENCES "users" ("id") ON DELETE SET NULL;
+
+-- The current content of every article becomes its first revision
+INSERT INTO "article_revisions" ("article_id", "revision", "title", "description", "body", "editor_id", "createdat")
+SELECT "id", 1, "title", "description", "body", "author_id", "updatedat"
+FROM "articles";
+
+COMMIT;
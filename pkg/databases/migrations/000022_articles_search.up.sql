@@ -0,0 +1,12 @@
+BEGIN;
+
+-- Title weighs more than the description, which weighs more than the body
+ALTER TABLE "articles" ADD COLUMN "search" tsvector GENERATED ALWAYS AS (
+  setweight(to_tsvector('english', coalesce("title", '')), 'A') ||
+  setweight(to_tsvector('english', coalesce("description", '')), 'B') ||
+  setweight(to_tsvector('english', coalesce("body", '')), 'C')
+) STORED;
+
+CREATE INDEX "articles_search_idx" ON "articles" USING GIN ("search");
+
+COMMIT;
@@ -0,0 +1,30 @@
+BEGIN;
+
+-- Emails are stored and compared lowercased. Addresses that only differ by
+-- case belong to separate accounts which have to be merged by hand first.
+DO $$
+DECLARE
+  "duplicates" TEXT;
+BEGIN
+  SELECT string_agg("email", ', ')
+  INTO "duplicates"
+  FROM (
+    SELECT lower("email") AS "email"
+    FROM "users"
+    WHERE "email" IS NOT NULL
+    GROUP BY lower("email")
+    HAVING COUNT(*) > 1
+  ) AS "d";
+
+  IF "duplicates" IS NOT NULL THEN
+    RAISE EXCEPTION 'users share an email regardless of case: %', "duplicates";
+  END IF;
+END $$;
+
+UPDATE "users" SET "email" = lower("email") WHERE "email" <> lower("email");
+
+-- The sign-up and identity inserts report a used email by this name,
+-- anonymized users keep a NULL email which never collides
+CREATE UNIQUE INDEX "users_email_key" ON "users" (lower("email"));
+
+COMMIT;
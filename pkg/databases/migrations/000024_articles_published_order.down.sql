@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP INDEX IF EXISTS "articles_published_order_idx";
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+-- Published lists are ordered and paged by publish time, then id
+CREATE INDEX "articles_published_order_idx" ON "articles" ("publish_at" DESC, "id" DESC) WHERE "status" = 'published';
+
+COMMIT;
@@ -0,0 +1,185 @@
+package diff
+
+import "strings"
+
+type Op string
+
+const (
+	Equal  Op = "equal"
+	Insert Op = "insert"
+	Delete Op = "delete"
+)
+
+// maxEdits bounds the search for a split, texts further apart than this are
+// shown replaced in full rather than spending seconds on a minimal diff.
+const maxEdits = 1000
+
+type Line struct {
+	Op   Op     `json:"op"`
+	Text string `json:"text"`
+}
+
+// Lines diffs a and b line by line. It is Myers' diff in linear space: the
+// common head and tail are cut, then the rest is split at the middle of an
+// shortest edit path and both halves are diffed the same way. Memory stays
+// proportional to the texts however far apart they are.
+func Lines(a, b string) []Line {
+	x, y := split(a), split(b)
+	lines := make([]Line, 0, len(x)+len(y))
+	return compare(lines, x, y)
+}
+
+// compare appends the lines turning x into y.
+func compare(lines []Line, x, y []string) []Line {
+	head := 0
+	for head < len(x) && head < len(y) && x[head] == y[head] {
+		head++
+	}
+	tail := 0
+	for tail < len(x)-head && tail < len(y)-head && x[len(x)-1-tail] == y[len(y)-1-tail] {
+		tail++
+	}
+
+	for _, text := range x[:head] {
+		lines = append(lines, Line{Op: Equal, Text: text})
+	}
+	lines = middle(lines, x[head:len(x)-tail], y[head:len(y)-tail])
+	for _, text := range x[len(x)-tail:] {
+		lines = append(lines, Line{Op: Equal, Text: text})
+	}
+	return lines
+}
+
+// middle diffs texts whose first and last lines differ.
+func middle(lines []Line, x, y []string) []Line {
+	switch {
+	case len(x) == 0:
+		return appendOp(lines, Insert, y)
+	case len(y) == 0:
+		return appendOp(lines, Delete, x)
+	case len(x) == 1 || len(y) == 1:
+		// A single line is either kept once or replaced
+		for i := range y {
+			if len(x) == 1 && x[0] == y[i] {
+				lines = appendOp(lines, Insert, y[:i])
+				lines = append(lines, Line{Op: Equal, Text: x[0]})
+				return appendOp(lines, Insert, y[i+1:])
+			}
+		}
+		for i := range x {
+			if len(y) == 1 && x[i] == y[0] {
+				lines = appendOp(lines, Delete, x[:i])
+				lines = append(lines, Line{Op: Equal, Text: y[0]})
+				return appendOp(lines, Delete, x[i+1:])
+			}
+		}
+		lines = appendOp(lines, Delete, x)
+		return appendOp(lines, Insert, y)
+	}
+
+	i, j, ok := bisect(x, y)
+	if !ok {
+		// Nothing in common, or too little to be worth the search
+		lines = appendOp(lines, Delete, x)
+		return appendOp(lines, Insert, y)
+	}
+	lines = compare(lines, x[:i], y[:j])
+	return compare(lines, x[i:], y[j:])
+}
+
+// bisect finds where the forward and the reverse search for a shortest
+// edit path meet, which splits the diff into two smaller ones. Both searches
+// only keep the furthest point reached on each diagonal.
+func bisect(x, y []string) (int, int, bool) {
+	n, m := len(x), len(y)
+	maxD := (n + m + 1) / 2
+	offset := maxD + 1
+	size := 2*maxD + 3
+	forward := make([]int, size)
+	reverse := make([]int, size)
+	for k := range forward {
+		forward[k] = -1
+		reverse[k] = -1
+	}
+	forward[offset+1] = 0
+	reverse[offset+1] = 0
+
+	delta := n - m
+	// With an odd delta the paths meet on a forward step, else a reverse one
+	odd := delta%2 != 0
+	kStart, kEnd, rStart, rEnd := 0, 0, 0, 0
+
+	for d := 0; d <= maxD && d <= maxEdits; d++ {
+		for k := -d + kStart; k <= d-kEnd; k += 2 {
+			var i int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				i = forward[offset+k+1]
+			} else {
+				i = forward[offset+k-1] + 1
+			}
+			j := i - k
+			for i < n && j < m && x[i] == y[j] {
+				i++
+				j++
+			}
+			forward[offset+k] = i
+			switch {
+			case i > n:
+				kEnd += 2
+			case j > m:
+				kStart += 2
+			case odd:
+				r := offset + delta - k
+				if r >= 0 && r < size && reverse[r] != -1 && i >= n-reverse[r] {
+					return i, j, true
+				}
+			}
+		}
+
+		for k := -d + rStart; k <= d-rEnd; k += 2 {
+			var i int
+			if k == -d || (k != d && reverse[offset+k-1] < reverse[offset+k+1]) {
+				i = reverse[offset+k+1]
+			} else {
+				i = reverse[offset+k-1] + 1
+			}
+			j := i - k
+			for i < n && j < m && x[n-i-1] == y[m-j-1] {
+				i++
+				j++
+			}
+			reverse[offset+k] = i
+			switch {
+			case i > n:
+				rEnd += 2
+			case j > m:
+				rStart += 2
+			case !odd:
+				f := offset + delta - k
+				if f >= 0 && f < size && forward[f] != -1 {
+					fi := forward[f]
+					fj := fi - (f - offset)
+					if fi >= n-i {
+						return fi, fj, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+func appendOp(lines []Line, op Op, texts []string) []Line {
+	for _, text := range texts {
+		lines = append(lines, Line{Op: op, Text: text})
+	}
+	return lines
+}
+
+// split treats an empty text as no lines rather than one empty line.
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+}
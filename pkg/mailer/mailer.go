@@ -0,0 +1,102 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NattpkJsw/real-world-api-go/config"
+)
+
+type IMailer interface {
+	Send(msg *Message) error
+}
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type smtpMailer struct {
+	cfg config.IMailConfig
+}
+
+// writerMailer prints the whole message instead of delivering it, it backs
+// the file and stdout drivers used for local development and tests.
+type writerMailer struct {
+	mu     sync.Mutex
+	from   string
+	path   string
+	writer io.Writer
+}
+
+func NewMailer(cfg config.IMailConfig) IMailer {
+	switch cfg.Driver() {
+	case "smtp":
+		return &smtpMailer{
+			cfg: cfg,
+		}
+	case "file":
+		return &writerMailer{
+			from: cfg.From(),
+			path: cfg.FilePath(),
+		}
+	default:
+		return &writerMailer{
+			from:   cfg.From(),
+			writer: os.Stdout,
+		}
+	}
+}
+
+func (m *Message) build(from string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(m.Body)
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+func (m *smtpMailer) Send(msg *Message) error {
+	var auth smtp.Auth
+	if m.cfg.Username() != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username(), m.cfg.Password(), m.cfg.Host())
+	}
+
+	addr := fmt.Sprintf("%s:%d", m.cfg.Host(), m.cfg.Port())
+	if err := smtp.SendMail(addr, auth, m.cfg.From(), []string{msg.To}, msg.build(m.cfg.From())); err != nil {
+		return fmt.Errorf("send mail failed: %v", err)
+	}
+	return nil
+}
+
+func (m *writerMailer) Send(msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writer := m.writer
+	if writer == nil {
+		file, err := os.OpenFile(m.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			return fmt.Errorf("open mail file failed: %v", err)
+		}
+		defer file.Close()
+		writer = file
+	}
+
+	if _, err := writer.Write(append(msg.build(m.from), []byte("\r\n")...)); err != nil {
+		return fmt.Errorf("write mail failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/NattpkJsw/real-world-api-go/pkg/mailer"
+)
+
+type Event string
+
+const (
+	// NewDevice is sent after a successful login from a device the user's
+	// login history has not seen
+	NewDevice Event = "new_device"
+)
+
+type INotifier interface {
+	Notify(n *Notification) error
+}
+
+type Notification struct {
+	Event   Event  `json:"event"`
+	UserId  int    `json:"userId"`
+	Email   string `json:"email"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+type mailNotifier struct {
+	mailer mailer.IMailer
+}
+
+// webhookNotifier posts the notification as json, leaving the delivery to
+// whatever listens on the url.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+type noopNotifier struct{}
+
+// NewNotifier picks the notifier by driver: "mail" (the default) sends the
+// notification to the user's email, "webhook" posts it to webhookUrl and
+// "none" drops it.
+func NewNotifier(driver, webhookUrl string, mail mailer.IMailer) INotifier {
+	switch driver {
+	case "webhook":
+		return &webhookNotifier{
+			url:    webhookUrl,
+			client: &http.Client{Timeout: 5 * time.Second},
+		}
+	case "none":
+		return &noopNotifier{}
+	default:
+		return &mailNotifier{
+			mailer: mail,
+		}
+	}
+}
+
+func (n *mailNotifier) Notify(notification *Notification) error {
+	return n.mailer.Send(&mailer.Message{
+		To:      notification.Email,
+		Subject: notification.Subject,
+		Body:    notification.Body,
+	})
+}
+
+func (n *webhookNotifier) Notify(notification *Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("marshal notification failed: %v", err)
+	}
+
+	res, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("post notification failed: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("post notification failed: status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (n *noopNotifier) Notify(notification *Notification) error { return nil }
@@ -0,0 +1,139 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// keysRefreshInterval limits how often an unknown kid makes us fetch the
+// provider keys again.
+const keysRefreshInterval = time.Minute
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (c *client) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key, ok := c.findKey(kid)
+	if !ok && time.Since(c.keysFetchedAt) > keysRefreshInterval {
+		if err := c.fetchKeys(); err != nil {
+			return nil, err
+		}
+		key, ok = c.findKey(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("signing key %q is unknown", kid)
+	}
+	return key, nil
+}
+
+// findKey falls back to the only key when the token has no kid.
+func (c *client) findKey(kid string) (any, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+func (c *client) fetchKeys() error {
+	c.keysFetchedAt = time.Now()
+
+	set := struct {
+		Keys []*jwk `json:"keys"`
+	}{}
+	if err := c.getJSON(c.discovery.JwksUri, &set); err != nil {
+		return fmt.Errorf("get provider keys failed: %v", err)
+	}
+
+	keys := make(map[string]any)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	c.keys = keys
+	return nil
+}
+
+func (k *jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("curve %s is not supported", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("curve %s is not supported", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("key is invalid")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("key type %s is not supported", k.Kty)
+	}
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(bytes) == 0 {
+		return nil, fmt.Errorf("key is invalid")
+	}
+	return new(big.Int).SetBytes(bytes), nil
+}
@@ -0,0 +1,268 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Provider is one OpenID Connect identity provider the users can log in with.
+type Provider struct {
+	Name         string
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectUrl  string
+	Scopes       []string
+}
+
+// Claims are the id token claims used to find or create the user.
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	Picture           string
+}
+
+type IClient interface {
+	// AuthCodeURL is where the user is sent to log in, codeChallenge is the
+	// S256 challenge of the PKCE verifier kept for Exchange.
+	AuthCodeURL(state, nonce, codeChallenge string) (string, error)
+	// Exchange trades the authorization code for the raw id token.
+	Exchange(code, codeVerifier string) (string, error)
+	VerifyIdToken(rawIdToken, nonce string) (*Claims, error)
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+type client struct {
+	provider   *Provider
+	httpClient *http.Client
+
+	mu            sync.Mutex
+	discovery     *discovery
+	keys          map[string]any
+	keysFetchedAt time.Time
+}
+
+// NewClient reads the provider discovery document and keys lazily, on the
+// first login, so an unreachable provider does not stop the server.
+func NewClient(provider *Provider, httpClient *http.Client) IClient {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &client{
+		provider:   provider,
+		httpClient: httpClient,
+	}
+}
+
+// NewCodeVerifier returns a random PKCE code verifier.
+func NewCodeVerifier() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// CodeChallenge returns the S256 challenge of a PKCE code verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (c *client) AuthCodeURL(state, nonce, codeChallenge string) (string, error) {
+	d, err := c.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", c.provider.ClientId)
+	params.Set("redirect_uri", c.provider.RedirectUrl)
+	params.Set("scope", strings.Join(c.provider.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return d.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+func (c *client) Exchange(code, codeVerifier string) (string, error) {
+	d, err := c.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.provider.RedirectUrl)
+	form.Set("client_id", c.provider.ClientId)
+	form.Set("code_verifier", codeVerifier)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("exchange code failed: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.provider.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.provider.ClientId), url.QueryEscape(c.provider.ClientSecret))
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("exchange code failed: %v", err)
+	}
+	defer res.Body.Close()
+
+	body := struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("exchange code failed: %s", res.Status)
+	}
+	if body.Error != "" {
+		return "", fmt.Errorf("exchange code failed: %s %s", body.Error, body.ErrorDescription)
+	}
+	if res.StatusCode != http.StatusOK || body.IdToken == "" {
+		return "", fmt.Errorf("exchange code failed: no id token in %s response", res.Status)
+	}
+	return body.IdToken, nil
+}
+
+type idTokenClaims struct {
+	Email             string   `json:"email"`
+	EmailVerified     flexBool `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	Picture           string   `json:"picture"`
+	Nonce             string   `json:"nonce"`
+	AuthorizedParty   string   `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// flexBool accepts "true" as well, some providers send email_verified as a string.
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	*b = flexBool(strings.Trim(string(data), `"`) == "true")
+	return nil
+}
+
+func (c *client) VerifyIdToken(rawIdToken, nonce string) (*Claims, error) {
+	d, err := c.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.ParseWithClaims(rawIdToken, &idTokenClaims{}, c.keyFunc,
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(c.provider.ClientId),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("id token is invalid: %v", err)
+	}
+
+	claims, ok := token.Claims.(*idTokenClaims)
+	if !ok {
+		return nil, fmt.Errorf("id token is invalid: claims type is invalid")
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("id token is invalid: nonce does not match")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != c.provider.ClientId {
+		return nil, fmt.Errorf("id token is invalid: azp does not match")
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("id token is invalid: subject is missing")
+	}
+
+	return &Claims{
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     bool(claims.EmailVerified),
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+		Picture:           claims.Picture,
+	}, nil
+}
+
+func (c *client) getDiscovery() (*discovery, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.discovery != nil {
+		return c.discovery, nil
+	}
+
+	issuer := strings.TrimSuffix(c.provider.Issuer, "/")
+	d := new(discovery)
+	if err := c.getJSON(issuer+"/.well-known/openid-configuration", d); err != nil {
+		return nil, fmt.Errorf("get discovery document failed: %v", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("get discovery document failed: issuer %s does not match", d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JwksUri == "" {
+		return nil, fmt.Errorf("get discovery document failed: endpoints are missing")
+	}
+	c.discovery = d
+	return d, nil
+}
+
+func (c *client) getJSON(uri string, out any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", uri, res.Status)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(out)
+}
@@ -0,0 +1,128 @@
+package passwords
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+type IBreachedList interface {
+	Contains(password string) (bool, error)
+	Close() error
+}
+
+// breachedFile is a text file of upper case hex SHA-1 hashes, one per line
+// and sorted, e.g. the "ordered by hash" Pwned Passwords download. A line may
+// hold a prefix of the hash only and anything after a colon is ignored. The
+// file is binary searched on disk, it is never loaded into memory.
+type breachedFile struct {
+	file *os.File
+	size int64
+}
+
+// maxLineLength bounds a line, a hash with a count is about 50 bytes.
+const maxLineLength = 256
+
+func OpenBreachedList(path string) (IBreachedList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open breached password list failed: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("open breached password list failed: %v", err)
+	}
+	return &breachedFile{
+		file: file,
+		size: info.Size(),
+	}, nil
+}
+
+func (b *breachedFile) Close() error {
+	return b.file.Close()
+}
+
+func (b *breachedFile) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	target := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	// Lines starting in [lo, hi) are left to look at
+	lo, hi := int64(0), b.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, err := b.lineStart(mid)
+		if err != nil {
+			return false, err
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+
+		line, err := b.readLine(start)
+		if err != nil {
+			return false, err
+		}
+		hash := parseHash(line)
+		if hash == "" || len(hash) > len(target) {
+			// Skip a blank or broken line
+			lo = start + int64(len(line)) + 1
+			continue
+		}
+
+		switch strings.Compare(target[:len(hash)], hash) {
+		case 0:
+			return true, nil
+		case -1:
+			hi = mid
+		default:
+			lo = start + int64(len(line)) + 1
+		}
+	}
+	return false, nil
+}
+
+// lineStart returns the offset of the first line starting at or after pos.
+func (b *breachedFile) lineStart(pos int64) (int64, error) {
+	if pos == 0 {
+		return 0, nil
+	}
+	buf := make([]byte, maxLineLength)
+	for offset := pos - 1; offset < b.size; offset += int64(len(buf)) {
+		n, err := b.file.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return 0, fmt.Errorf("read breached password list failed: %v", err)
+		}
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return offset + int64(i) + 1, nil
+		}
+		if n < len(buf) {
+			break
+		}
+	}
+	return b.size, nil
+}
+
+// readLine returns the line at start without its line break.
+func (b *breachedFile) readLine(start int64) ([]byte, error) {
+	buf := make([]byte, maxLineLength)
+	n, err := b.file.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("read breached password list failed: %v", err)
+	}
+	line := buf[:n]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	return line, nil
+}
+
+func parseHash(line []byte) string {
+	hash, _, _ := strings.Cut(string(line), ":")
+	return strings.ToUpper(strings.TrimSpace(hash))
+}
@@ -0,0 +1,118 @@
+package passwords
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxLength is the bcrypt limit, the bytes after it would be ignored.
+const MaxLength = 72
+
+type Class string
+
+const (
+	Upper  Class = "upper"
+	Lower  Class = "lower"
+	Digit  Class = "digit"
+	Symbol Class = "symbol"
+)
+
+type Policy struct {
+	MinLength int
+	Require   []Class
+}
+
+type IChecker interface {
+	// Check returns every rule the password breaks, userInputs are the
+	// username, the email and the like which must not appear in it.
+	Check(password string, userInputs ...string) []string
+}
+
+type checker struct {
+	policy   *Policy
+	breached IBreachedList
+}
+
+// NewChecker checks passwords against the policy and, when breached is not
+// nil, against a list of breached passwords.
+func NewChecker(policy *Policy, breached IBreachedList) IChecker {
+	return &checker{
+		policy:   policy,
+		breached: breached,
+	}
+}
+
+func (c *checker) Check(password string, userInputs ...string) []string {
+	if password == "" {
+		return []string{"can't be blank"}
+	}
+
+	problems := make([]string, 0)
+	if utf8.RuneCountInString(password) < c.policy.MinLength {
+		problems = append(problems, fmt.Sprintf("is too short (minimum is %d characters)", c.policy.MinLength))
+	}
+	if len(password) > MaxLength {
+		problems = append(problems, fmt.Sprintf("is too long (maximum is %d bytes)", MaxLength))
+	}
+
+	for _, class := range c.policy.Require {
+		if !hasClass(password, class) {
+			problems = append(problems, "must contain "+classNames[class])
+		}
+	}
+
+	lower := strings.ToLower(password)
+	for _, input := range userInputs {
+		// The part before @ is what people reuse from an email
+		input, _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(input)), "@")
+		if len(input) >= 3 && strings.Contains(lower, input) {
+			problems = append(problems, "must not contain your username or email")
+			break
+		}
+	}
+
+	if c.breached != nil && len(problems) == 0 {
+		found, err := c.breached.Contains(password)
+		if err != nil {
+			// An unreadable list must not block every sign up
+			return problems
+		}
+		if found {
+			problems = append(problems, "has appeared in a data breach, please choose another one")
+		}
+	}
+	return problems
+}
+
+func hasClass(password string, class Class) bool {
+	for _, r := range password {
+		switch class {
+		case Upper:
+			if unicode.IsUpper(r) {
+				return true
+			}
+		case Lower:
+			if unicode.IsLower(r) {
+				return true
+			}
+		case Digit:
+			if unicode.IsDigit(r) {
+				return true
+			}
+		case Symbol:
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var classNames = map[Class]string{
+	Upper:  "an uppercase letter",
+	Lower:  "a lowercase letter",
+	Digit:  "a digit",
+	Symbol: "a symbol",
+}
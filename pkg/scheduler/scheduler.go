@@ -0,0 +1,83 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Job is one run of a background task, the context is cancelled when the
+// server shuts down.
+type Job func(ctx context.Context) error
+
+type IScheduler interface {
+	Every(name string, interval time.Duration, job Job)
+	Start(ctx context.Context)
+	Wait()
+}
+
+type task struct {
+	name     string
+	interval time.Duration
+	job      Job
+}
+
+type scheduler struct {
+	tasks []*task
+	wg    sync.WaitGroup
+}
+
+// NewScheduler runs jobs on fixed intervals inside the server process. Every
+// instance runs them, so a job must be safe to run concurrently, e.g. by
+// locking its rows with SKIP LOCKED.
+func NewScheduler() IScheduler {
+	return new(scheduler)
+}
+
+func (s *scheduler) Every(name string, interval time.Duration, job Job) {
+	s.tasks = append(s.tasks, &task{
+		name:     name,
+		interval: interval,
+		job:      job,
+	})
+}
+
+// Start runs every job once right away and then on its interval, until the
+// context is cancelled.
+func (s *scheduler) Start(ctx context.Context) {
+	for _, t := range s.tasks {
+		s.wg.Add(1)
+		go func(t *task) {
+			defer s.wg.Done()
+
+			ticker := time.NewTicker(t.interval)
+			defer ticker.Stop()
+			for {
+				t.run(ctx)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(t)
+	}
+}
+
+// Wait blocks until every job has returned after the context is cancelled.
+func (s *scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// run keeps a failing or panicking job from stopping the others.
+func (t *task) run(ctx context.Context) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("job %s panicked: %v", t.name, r)
+		}
+	}()
+	if err := t.job(ctx); err != nil {
+		log.Printf("job %s failed: %v", t.name, err)
+	}
+}
@@ -0,0 +1,82 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	attempt   Attempt
+	expiresAt time.Time
+}
+
+// memoryStore keeps attempts in the process, it suits a single instance.
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+func MemoryStore() IStore {
+	return &memoryStore{
+		entries: make(map[string]*memoryEntry),
+	}
+}
+
+func (s *memoryStore) Get(key string) (*Attempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(s.entries, key)
+		return nil, nil
+	}
+	attempt := entry.attempt
+	return &attempt, nil
+}
+
+func (s *memoryStore) Increment(key string, now time.Time, window, ttl time.Duration) (*Attempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiresAt := time.Now()
+	for k, entry := range s.entries {
+		if expiresAt.After(entry.expiresAt) {
+			delete(s.entries, k)
+		}
+	}
+
+	entry, ok := s.entries[key]
+	if !ok || now.Sub(entry.attempt.LastFailureAt) > window {
+		entry = new(memoryEntry)
+		s.entries[key] = entry
+	}
+	entry.attempt.Failures++
+	entry.attempt.LastFailureAt = now
+	entry.expiresAt = expiresAt.Add(ttl)
+
+	attempt := entry.attempt
+	return &attempt, nil
+}
+
+func (s *memoryStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok {
+		entry.attempt.Failures = 0
+		entry.attempt.LockedUntil = until
+	}
+	return nil
+}
+
+func (s *memoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
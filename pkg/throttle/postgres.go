@@ -0,0 +1,115 @@
+package throttle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// postgresStore shares attempts between every instance using the database.
+type postgresStore struct {
+	db *sqlx.DB
+}
+
+func PostgresStore(db *sqlx.DB) IStore {
+	return &postgresStore{
+		db: db,
+	}
+}
+
+func (s *postgresStore) Get(key string) (*Attempt, error) {
+	query := `
+	SELECT
+		"failures",
+		"last_failure_at",
+		"locked_until"
+	FROM "login_attempts"
+	WHERE "key" = $1 AND "expires_at" > CURRENT_TIMESTAMP;`
+
+	attempt := new(Attempt)
+	if err := s.db.Get(attempt, query, key); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get login attempt failed: %v", err)
+	}
+	return attempt, nil
+}
+
+func (s *postgresStore) Increment(key string, now time.Time, window, ttl time.Duration) (*Attempt, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The count is raised in place so concurrent failures are all kept, a
+	// record past its window or its expiry starts over from one
+	query := `
+	INSERT INTO "login_attempts" (
+		"key",
+		"failures",
+		"last_failure_at",
+		"locked_until",
+		"expires_at"
+	)
+	VALUES ($1, 1, $2, $3, $4)
+	ON CONFLICT ("key") DO UPDATE SET
+		"failures" = CASE
+			WHEN "login_attempts"."expires_at" <= CURRENT_TIMESTAMP OR "login_attempts"."last_failure_at" < $5 THEN 1
+			ELSE "login_attempts"."failures" + 1
+		END,
+		"locked_until" = CASE
+			WHEN "login_attempts"."expires_at" <= CURRENT_TIMESTAMP OR "login_attempts"."last_failure_at" < $5 THEN EXCLUDED."locked_until"
+			ELSE "login_attempts"."locked_until"
+		END,
+		"last_failure_at" = EXCLUDED."last_failure_at",
+		"expires_at" = EXCLUDED."expires_at"
+	RETURNING
+		"failures",
+		"last_failure_at",
+		"locked_until";`
+
+	attempt := new(Attempt)
+	if err := s.db.GetContext(
+		ctx,
+		attempt,
+		query,
+		key,
+		now,
+		time.Time{},
+		time.Now().Add(ttl),
+		now.Add(-window),
+	); err != nil {
+		return nil, fmt.Errorf("increment login attempt failed: %v", err)
+	}
+
+	cleanQuery := `
+	DELETE FROM "login_attempts" WHERE "expires_at" < CURRENT_TIMESTAMP;`
+	if _, err := s.db.ExecContext(ctx, cleanQuery); err != nil {
+		return nil, fmt.Errorf("clean login attempts failed: %v", err)
+	}
+	return attempt, nil
+}
+
+func (s *postgresStore) Lock(key string, until time.Time) error {
+	query := `
+	UPDATE "login_attempts" SET
+		"failures" = 0,
+		"locked_until" = $2
+	WHERE "key" = $1;`
+	if _, err := s.db.ExecContext(context.Background(), query, key, until); err != nil {
+		return fmt.Errorf("lock login attempt failed: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresStore) Delete(key string) error {
+	query := `
+	DELETE FROM "login_attempts" WHERE "key" = $1;`
+	if _, err := s.db.ExecContext(context.Background(), query, key); err != nil {
+		return fmt.Errorf("delete login attempt failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package throttle
+
+import (
+	"time"
+)
+
+type IThrottle interface {
+	Check(key string) (time.Duration, error)
+	Fail(key string) (time.Duration, error)
+	Reset(key string) error
+}
+
+type IStore interface {
+	Get(key string) (*Attempt, error)
+	// Increment atomically adds a failure at now and answers the record
+	// after it, a record whose last failure is older than the window starts
+	// over. Concurrent failures of a key must all be counted.
+	Increment(key string, now time.Time, window, ttl time.Duration) (*Attempt, error)
+	// Lock locks the key until the given time and clears its failures.
+	Lock(key string, until time.Time) error
+	Delete(key string) error
+}
+
+// Attempt is the failure record kept for one key, e.g. an email or an ip.
+type Attempt struct {
+	Failures      int       `db:"failures"`
+	LastFailureAt time.Time `db:"last_failure_at"`
+	LockedUntil   time.Time `db:"locked_until"`
+}
+
+type Policy struct {
+	MaxAttempts     int           // failures before the key is locked
+	LockoutDuration time.Duration // how long a lock lasts
+	BackoffAfter    int           // failures allowed before back-off starts
+	BackoffBase     time.Duration // first back-off delay, doubled on each failure
+	Window          time.Duration // failures older than this are forgotten
+}
+
+type throttle struct {
+	store  IStore
+	policy *Policy
+	now    func() time.Time
+}
+
+func NewThrottle(store IStore, policy *Policy) IThrottle {
+	return NewThrottleWithClock(store, policy, time.Now)
+}
+
+// NewThrottleWithClock is NewThrottle reading the time from now, tests use
+// it to move the clock.
+func NewThrottleWithClock(store IStore, policy *Policy, now func() time.Time) IThrottle {
+	return &throttle{
+		store:  store,
+		policy: policy,
+		now:    now,
+	}
+}
+
+// Check returns how long the key has to wait before it may try again,
+// zero means the attempt is allowed.
+func (t *throttle) Check(key string) (time.Duration, error) {
+	attempt, err := t.store.Get(key)
+	if err != nil || attempt == nil {
+		return 0, err
+	}
+	return t.retryAfter(attempt), nil
+}
+
+// Fail records a failed attempt and returns the wait it causes.
+func (t *throttle) Fail(key string) (time.Duration, error) {
+	now := t.now()
+	ttl := t.policy.Window
+	if t.policy.LockoutDuration > ttl {
+		ttl = t.policy.LockoutDuration
+	}
+
+	attempt, err := t.store.Increment(key, now, t.policy.Window, ttl)
+	if err != nil {
+		return 0, err
+	}
+	if attempt.Failures >= t.policy.MaxAttempts {
+		// Start over once the lock is served
+		attempt.Failures = 0
+		attempt.LockedUntil = now.Add(t.policy.LockoutDuration)
+		if err := t.store.Lock(key, attempt.LockedUntil); err != nil {
+			return 0, err
+		}
+	}
+	return t.retryAfter(attempt), nil
+}
+
+func (t *throttle) Reset(key string) error {
+	return t.store.Delete(key)
+}
+
+func (t *throttle) retryAfter(attempt *Attempt) time.Duration {
+	now := t.now()
+	if attempt.LockedUntil.After(now) {
+		return attempt.LockedUntil.Sub(now)
+	}
+	if attempt.Failures < t.policy.BackoffAfter || now.Sub(attempt.LastFailureAt) > t.policy.Window {
+		return 0
+	}
+
+	wait := t.policy.BackoffBase << (attempt.Failures - t.policy.BackoffAfter)
+	if wait <= 0 || wait > t.policy.LockoutDuration {
+		wait = t.policy.LockoutDuration
+	}
+	if next := attempt.LastFailureAt.Add(wait); next.After(now) {
+		return next.Sub(now)
+	}
+	return 0
+}
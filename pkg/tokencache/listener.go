@@ -0,0 +1,118 @@
+package tokencache
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+)
+
+// The oauth table trigger notifies Channel with the access token hash of
+// every deleted or rotated session, and RevokedChannel with "jti:expires"
+// of its access token.
+const (
+	Channel        = "oauth_revoked"
+	RevokedChannel = "token_revoked"
+)
+
+// Listen holds one connection of the pool on LISTEN and evicts the revoked
+// tokens until ctx is done. The cache stays disabled while not listening.
+func Listen(ctx context.Context, db *sqlx.DB, cache ICache) {
+	backoff := time.Second
+	for {
+		startedAt := time.Now()
+		err := listen(ctx, db, cache)
+		cache.SetEnabled(false)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(startedAt) > time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("token cache listener failed, retry in %v: %v", backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func listen(ctx context.Context, db *sqlx.DB, cache ICache) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The connection is always handed back as bad, it must not return to the
+	// pool while it is still listening.
+	return conn.Raw(func(driverConn any) error {
+		pgxConn := driverConn.(*stdlib.Conn).Conn()
+		if _, err := pgxConn.Exec(ctx, "LISTEN "+Channel+"; LISTEN "+RevokedChannel); err != nil {
+			return fmt.Errorf("%w: listen failed: %v", driver.ErrBadConn, err)
+		}
+		revoked, err := loadRevoked(ctx, pgxConn)
+		if err != nil {
+			return fmt.Errorf("%w: load revoked tokens failed: %v", driver.ErrBadConn, err)
+		}
+		cache.LoadRevoked(revoked)
+		cache.SetEnabled(true)
+
+		for {
+			notification, err := pgxConn.WaitForNotification(ctx)
+			if err != nil {
+				return fmt.Errorf("%w: wait for notification failed: %v", driver.ErrBadConn, err)
+			}
+			switch notification.Channel {
+			case Channel:
+				cache.Delete(notification.Payload)
+			case RevokedChannel:
+				jti, exp, ok := strings.Cut(notification.Payload, ":")
+				unix, err := strconv.ParseInt(exp, 10, 64)
+				if !ok || err != nil {
+					log.Printf("token cache listener: bad payload %q", notification.Payload)
+					continue
+				}
+				cache.Revoke(jti, time.Unix(unix, 0))
+			}
+		}
+	})
+}
+
+func loadRevoked(ctx context.Context, conn *pgx.Conn) (map[string]time.Time, error) {
+	query := `
+	SELECT
+		"jti",
+		"expires_at"
+	FROM "revoked_tokens"
+	WHERE "expires_at" > CURRENT_TIMESTAMP;`
+
+	rows, err := conn.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revoked := make(map[string]time.Time)
+	for rows.Next() {
+		var jti string
+		var expiresAt time.Time
+		if err := rows.Scan(&jti, &expiresAt); err != nil {
+			return nil, err
+		}
+		revoked[jti] = expiresAt
+	}
+	return revoked, rows.Err()
+}
@@ -0,0 +1,189 @@
+package tokencache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// ICache remembers access tokens that were found in the oauth table, keyed
+// by the token hash. It only answers while it is enabled, the listener
+// disables it whenever revocations from other instances could be missed.
+//
+// It also holds the denylist of revoked token ids used by stateless
+// validation, which is kept across listener outages and reloaded after.
+type ICache interface {
+	Get(tokenHash string) (int, bool)
+	// Version changes on every invalidation, read it before looking the token
+	// up in the database and hand it to Set.
+	Version() uint64
+	// Set is ignored when anything was invalidated since version, the token
+	// may be the one that got revoked meanwhile.
+	Set(tokenHash string, userId int, version uint64)
+	Delete(tokenHash string)
+	DeleteUser(userId int)
+	SetEnabled(enabled bool)
+	Revoke(jti string, expiresAt time.Time)
+	IsRevoked(jti string) bool
+	LoadRevoked(revoked map[string]time.Time)
+}
+
+type entry struct {
+	tokenHash string
+	userId    int
+	expiresAt time.Time
+}
+
+type cache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	enabled bool
+	version uint64
+	items   map[string]*list.Element
+	order   *list.List // front is the most recently used
+	revoked map[string]time.Time
+	// purgeAt is the denylist size that triggers dropping expired entries
+	purgeAt int
+	now     func() time.Time
+}
+
+func NewCache(size int, ttl time.Duration) ICache {
+	return &cache{
+		size:    size,
+		ttl:     ttl,
+		items:   make(map[string]*list.Element),
+		order:   list.New(),
+		revoked: make(map[string]time.Time),
+		purgeAt: 1024,
+		now:     time.Now,
+	}
+}
+
+func (c *cache) Get(tokenHash string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.enabled {
+		return 0, false
+	}
+	el, ok := c.items[tokenHash]
+	if !ok {
+		return 0, false
+	}
+	e := el.Value.(*entry)
+	if !c.now().Before(e.expiresAt) {
+		c.remove(el)
+		return 0, false
+	}
+	c.order.MoveToFront(el)
+	return e.userId, true
+}
+
+func (c *cache) Version() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+func (c *cache) Set(tokenHash string, userId int, version uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.enabled || c.size <= 0 || version != c.version {
+		return
+	}
+	if el, ok := c.items[tokenHash]; ok {
+		c.remove(el)
+	}
+	c.items[tokenHash] = c.order.PushFront(&entry{
+		tokenHash: tokenHash,
+		userId:    userId,
+		expiresAt: c.now().Add(c.ttl),
+	})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *cache) Delete(tokenHash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.version++
+	if el, ok := c.items[tokenHash]; ok {
+		c.remove(el)
+	}
+}
+
+// DeleteUser drops every session of the user, for revocations where the
+// token hashes are not at hand.
+func (c *cache) DeleteUser(userId int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.version++
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*entry).userId == userId {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+// SetEnabled always empties the cache, what was cached before a listener
+// outage cannot be trusted afterwards.
+func (c *cache) SetEnabled(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.version++
+	c.enabled = enabled
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+func (c *cache) Revoke(jti string, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.revoked[jti] = expiresAt
+	if len(c.revoked) >= c.purgeAt {
+		now := c.now()
+		for id, exp := range c.revoked {
+			if !now.Before(exp) {
+				delete(c.revoked, id)
+			}
+		}
+		c.purgeAt = 2 * len(c.revoked)
+		if c.purgeAt < 1024 {
+			c.purgeAt = 1024
+		}
+	}
+}
+
+func (c *cache) IsRevoked(jti string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	exp, ok := c.revoked[jti]
+	return ok && c.now().Before(exp)
+}
+
+// LoadRevoked replaces the denylist with the one from the database.
+func (c *cache) LoadRevoked(revoked map[string]time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.revoked = revoked
+	c.purgeAt = 2 * len(revoked)
+	if c.purgeAt < 1024 {
+		c.purgeAt = 1024
+	}
+}
+
+func (c *cache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*entry).tokenHash)
+}
@@ -21,7 +21,7 @@ func TestFindSingleArticle(t *testing.T) {
 			slug:     "how-to-train-your-dragon",
 			userID:   0,
 			isErr:    false,
-			expected: `{"article":{"slug":"how-to-train-your-dragon","title":"How to train your dragon","description":"Ever wonder how?","body":"It takes a Jacobian","taglist":["sun","set"],"createdAt":"2024-02-04T13:57:19.098654","updatedAt":"2024-02-04T13:57:19.098654","favorited":false,"favoritesCount":2,"author":{"username":"jake","bio":"I work at statefarm","image":"https://i.stack.imgur.com/xHWG8.jpg","following":false}}}`,
+			expected: `{"article":{"slug":"how-to-train-your-dragon","title":"How to train your dragon","description":"Ever wonder how?","body":"It takes a Jacobian","taglist":["sun","set"],"createdAt":"2024-02-04T13:57:19.098654","updatedAt":"2024-02-04T13:57:19.098654","status":"published","publishAt":"2024-02-04T13:57:19.098654","favorited":false,"favoritesCount":2,"author":{"username":"jake","bio":"I work at statefarm","image":"https://i.stack.imgur.com/xHWG8.jpg","following":false}}}`,
 		},
 	}
 
@@ -0,0 +1,33 @@
+package unittest
+
+import (
+	"testing"
+
+	"github.com/NattpkJsw/real-world-api-go/modules/articles"
+)
+
+func TestArticleCursor(t *testing.T) {
+	cursor := articles.EncodeCursor("2024-03-01T10:20:30.123456", 42)
+	result, err := articles.DecodeCursor(cursor)
+	if err != nil {
+		t.Fatalf("decode cursor failed: %v", err)
+	}
+	if result.Time != "2024-03-01T10:20:30.123456" || result.Id != 42 {
+		t.Errorf("got %+v", result)
+	}
+
+	if result, err := articles.DecodeCursor(""); result != nil || err != nil {
+		t.Errorf("empty cursor: got %+v, %v", result, err)
+	}
+
+	invalid := []string{
+		"not base64!",
+		articles.EncodeCursor("yesterday", 1),
+		articles.EncodeCursor("2024-03-01T10:20:30", 0),
+	}
+	for _, cursor := range invalid {
+		if _, err := articles.DecodeCursor(cursor); err == nil {
+			t.Errorf("cursor %q: expected an error", cursor)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package unittest
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/NattpkJsw/real-world-api-go/pkg/diff"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want []diff.Line
+	}{
+		{
+			name: "unchanged",
+			a:    "one\ntwo",
+			b:    "one\ntwo",
+			want: []diff.Line{
+				{Op: diff.Equal, Text: "one"},
+				{Op: diff.Equal, Text: "two"},
+			},
+		},
+		{
+			name: "changed line",
+			a:    "one\ntwo\nthree",
+			b:    "one\n2\nthree",
+			want: []diff.Line{
+				{Op: diff.Equal, Text: "one"},
+				{Op: diff.Delete, Text: "two"},
+				{Op: diff.Insert, Text: "2"},
+				{Op: diff.Equal, Text: "three"},
+			},
+		},
+		{
+			name: "moved line",
+			a:    "a\nb\nc\nd",
+			b:    "b\nc\na\nd",
+			want: []diff.Line{
+				{Op: diff.Delete, Text: "a"},
+				{Op: diff.Equal, Text: "b"},
+				{Op: diff.Equal, Text: "c"},
+				{Op: diff.Insert, Text: "a"},
+				{Op: diff.Equal, Text: "d"},
+			},
+		},
+		{
+			name: "from empty",
+			a:    "",
+			b:    "one",
+			want: []diff.Line{
+				{Op: diff.Insert, Text: "one"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got := diff.Lines(test.a, test.b)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected: %v, got: %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestDiffLinesLarge(t *testing.T) {
+	// Far apart texts must still give a script rebuilding both sides
+	x := make([]string, 20000)
+	y := make([]string, 20000)
+	for i := range x {
+		x[i] = fmt.Sprintf("old %d", i)
+		y[i] = fmt.Sprintf("new %d", i)
+		if i%7 == 0 {
+			y[i] = x[i]
+		}
+	}
+	a, b := strings.Join(x, "\n"), strings.Join(y, "\n")
+
+	var gotA, gotB []string
+	for _, line := range diff.Lines(a, b) {
+		if line.Op != diff.Insert {
+			gotA = append(gotA, line.Text)
+		}
+		if line.Op != diff.Delete {
+			gotB = append(gotB, line.Text)
+		}
+	}
+	if strings.Join(gotA, "\n") != a || strings.Join(gotB, "\n") != b {
+		t.Errorf("diff does not rebuild the texts")
+	}
+}
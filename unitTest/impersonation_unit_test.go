@@ -0,0 +1,99 @@
+package unittest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NattpkJsw/real-world-api-go/config"
+	"github.com/NattpkJsw/real-world-api-go/modules/users"
+	usersRepositories "github.com/NattpkJsw/real-world-api-go/modules/users/usersRepositories"
+	usersUsecases "github.com/NattpkJsw/real-world-api-go/modules/users/usersUsecases"
+	"github.com/NattpkJsw/real-world-api-go/pkg/auth"
+	"github.com/NattpkJsw/real-world-api-go/pkg/throttle"
+	"github.com/NattpkJsw/real-world-api-go/pkg/tokencache"
+)
+
+const impersonationEnv = `APP_HOST=127.0.0.1
+APP_PORT=3000
+APP_NAME=realworld
+APP_VERSION=v1
+APP_READ_TIMEOUT=60
+APP_WRITE_TIMEOUT=60
+APP_BODY_LIMIT=10490000
+APP_FILE_LIMIT=2097000
+DB_HOST=127.0.0.1
+DB_PORT=5432
+DB_PROTOCOL=tcp
+DB_USERNAME=postgres
+DB_PASSWORD=postgres
+DB_DATABASE=realworld
+DB_SSL_MODE=disable
+DB_MAX_CONNECTIONS=25
+JWT_SECRET_KEY=test-secret
+JWT_API_KEY=test-api-key
+JWT_ACCESS_EXPIRES=86400
+JWT_REFRESH_EXPIRES=604800
+JWT_IMPERSONATION_EXPIRES=900
+`
+
+// usersRepositoryStub answers the calls a token refresh makes, any other
+// call panics on the nil interface.
+type usersRepositoryStub struct {
+	usersRepositories.IUsersRepository
+	oauth   *users.Oauth
+	updated *users.UserToken
+}
+
+func (r *usersRepositoryStub) FindOneOath(accessToken string) (*users.Oauth, error) {
+	return r.oauth, nil
+}
+
+func (r *usersRepositoryStub) GetProfile(userId int) (*users.User, error) {
+	return &users.User{Id: userId, Email: "target@example.com", Username: "target"}, nil
+}
+
+func (r *usersRepositoryStub) UpdateOauth(req *users.UserToken) error {
+	r.updated = req
+	return nil
+}
+
+func TestRefreshKeepsImpersonation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte(impersonationEnv), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.LoadConfig(path)
+
+	expiresAt := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+	repo := &usersRepositoryStub{
+		oauth: &users.Oauth{
+			Id:              "session",
+			UserId:          2,
+			Role:            users.UserRole,
+			ImpersonatorId:  1,
+			AccessExpiresAt: &expiresAt,
+		},
+	}
+	usecase := usersUsecases.UsersUsecase(cfg, repo, nil, throttle.MemoryStore(), tokencache.NewCache(0, time.Minute), nil, nil, nil)
+
+	passport, err := usecase.GetUser("impersonation-token")
+	if err != nil {
+		t.Fatalf("get user failed: %v", err)
+	}
+
+	claims, err := auth.ParseToken(cfg.Jwt(), passport.User.Token)
+	if err != nil {
+		t.Fatalf("parse token failed: %v", err)
+	}
+	if claims.Claims.ImpersonatorId != 1 {
+		t.Errorf("expect the impersonator kept, got %d", claims.Claims.ImpersonatorId)
+	}
+	if !claims.ExpiresAt.Time.Equal(expiresAt) {
+		t.Errorf("expect the expiry kept at %v, got %v", expiresAt, claims.ExpiresAt.Time)
+	}
+	if repo.updated == nil || !repo.updated.AccessExpiresAt.Equal(expiresAt) {
+		t.Errorf("expect the session stored with the original expiry, got %+v", repo.updated)
+	}
+}
@@ -0,0 +1,41 @@
+package unittest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NattpkJsw/real-world-api-go/pkg/notifier"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var received notifier.Notification
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("decode notification failed: %v", err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	notify := notifier.NewNotifier("webhook", srv.URL, nil)
+	sent := &notifier.Notification{
+		Event:   notifier.NewDevice,
+		UserId:  7,
+		Email:   "jake@jake.jake",
+		Subject: "New sign-in to your account",
+	}
+	if err := notify.Notify(sent); err != nil {
+		t.Fatalf("expected: %v, got: %v", nil, err)
+	}
+	if received != *sent {
+		t.Errorf("expected: %v, got: %v", *sent, received)
+	}
+
+	status = http.StatusInternalServerError
+	if err := notify.Notify(sent); err == nil {
+		t.Errorf("expected: %v, got: %v", "error", err)
+	}
+}
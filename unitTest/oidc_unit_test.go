@@ -0,0 +1,181 @@
+package unittest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NattpkJsw/real-world-api-go/pkg/oidc"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// stubIdp is a local OpenID Connect provider, it accepts the code "good-code"
+// when the PKCE verifier matches the challenge given to it.
+type stubIdp struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newStubIdp(t *testing.T) *stubIdp {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key failed: %v", err)
+	}
+	idp := &stubIdp{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "stub",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "good-code" || oidc.CodeChallenge(r.Form.Get("code_verifier")) != idp.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(t, idp.claims)})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *stubIdp) sign(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "stub"
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		t.Fatalf("sign id token failed: %v", err)
+	}
+	return signed
+}
+
+func (idp *stubIdp) client() oidc.IClient {
+	return oidc.NewClient(&oidc.Provider{
+		Name:        "stub",
+		Issuer:      idp.server.URL,
+		ClientId:    "realworld",
+		RedirectUrl: "http://localhost:3000/oidc/stub/callback",
+		Scopes:      []string{"openid", "email"},
+	}, idp.server.Client())
+}
+
+// login runs the authorization code flow the way the callback does
+func (idp *stubIdp) login(t *testing.T, client oidc.IClient, code string) (*oidc.Claims, error) {
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		t.Fatalf("generate code verifier failed: %v", err)
+	}
+	authUrl, err := client.AuthCodeURL("state", idp.nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		return nil, err
+	}
+	u, _ := url.Parse(authUrl)
+	idp.challenge = u.Query().Get("code_challenge")
+
+	rawIdToken, err := client.Exchange(code, verifier)
+	if err != nil {
+		return nil, err
+	}
+	return client.VerifyIdToken(rawIdToken, idp.nonce)
+}
+
+func (idp *stubIdp) idClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            idp.server.URL,
+		"aud":            "realworld",
+		"sub":            "248289761001",
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"nonce":          idp.nonce,
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+	}
+}
+
+func TestOidcLogin(t *testing.T) {
+	idp := newStubIdp(t)
+	idp.nonce = "n-0S6_WzA2Mj"
+	idp.claims = idp.idClaims()
+
+	claims, err := idp.login(t, idp.client(), "good-code")
+	if err != nil {
+		t.Fatalf("expected: %v, got: %v", nil, err.Error())
+	}
+	if claims.Subject != "248289761001" || claims.Email != "jane@example.com" || !claims.EmailVerified {
+		t.Errorf("expected: %v, got: %v", "jane@example.com", claims)
+	}
+}
+
+func TestOidcAuthCodeURL(t *testing.T) {
+	idp := newStubIdp(t)
+
+	authUrl, err := idp.client().AuthCodeURL("abc", "xyz", "challenge")
+	if err != nil {
+		t.Fatalf("expected: %v, got: %v", nil, err.Error())
+	}
+	if !strings.HasPrefix(authUrl, idp.server.URL+"/authorize?") {
+		t.Errorf("expected: %v, got: %v", idp.server.URL+"/authorize?", authUrl)
+	}
+	u, _ := url.Parse(authUrl)
+	if u.Query().Get("code_challenge_method") != "S256" || u.Query().Get("state") != "abc" || u.Query().Get("nonce") != "xyz" {
+		t.Errorf("expected: %v, got: %v", "S256 challenge with state and nonce", u.RawQuery)
+	}
+}
+
+type testOidcReject struct {
+	name   string
+	code   string
+	claims func(jwt.MapClaims)
+}
+
+func TestOidcLoginRejected(t *testing.T) {
+	tests := []testOidcReject{
+		{name: "bad code", code: "bad-code"},
+		{name: "wrong nonce", code: "good-code", claims: func(c jwt.MapClaims) { c["nonce"] = "replayed" }},
+		{name: "wrong audience", code: "good-code", claims: func(c jwt.MapClaims) { c["aud"] = "another-app" }},
+		{name: "wrong issuer", code: "good-code", claims: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{name: "expired", code: "good-code", claims: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+	}
+
+	for _, test := range tests {
+		idp := newStubIdp(t)
+		idp.nonce = "n-0S6_WzA2Mj"
+		idp.claims = idp.idClaims()
+		if test.claims != nil {
+			test.claims(idp.claims)
+		}
+
+		if _, err := idp.login(t, idp.client(), test.code); err == nil {
+			t.Errorf("%s expected: %v, got: %v", test.name, "error", nil)
+		}
+	}
+}
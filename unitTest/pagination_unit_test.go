@@ -0,0 +1,271 @@
+package unittest
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/NattpkJsw/real-world-api-go/modules/articles"
+	articleshandlers "github.com/NattpkJsw/real-world-api-go/modules/articles/articlesHandlers"
+	articlespatterns "github.com/NattpkJsw/real-world-api-go/modules/articles/articlesPatterns"
+	articlesusecases "github.com/NattpkJsw/real-world-api-go/modules/articles/articlesUsecases"
+	"github.com/gofiber/fiber/v2"
+)
+
+// articlesUsecaseStub answers a list page the way the repository would fill
+// its metadata, any other call panics on the nil interface.
+type articlesUsecaseStub struct {
+	articlesusecases.IArticlesUsecase
+	total      int
+	nextCursor string
+	restoreErr error
+}
+
+func (u *articlesUsecaseStub) RestoreRevision(slug string, userId, revision int) (*articles.JSONArticle, error) {
+	return nil, u.restoreErr
+}
+
+func (u *articlesUsecaseStub) GetArticlesList(req *articles.ArticleFilter, userId int) (*articles.ArticleList, error) {
+	return &articles.ArticleList{
+		Article:       make([]*articles.Article, 0),
+		ArticlesCount: u.total,
+		Limit:         req.Limit,
+		Offset:        req.Offset,
+		HasMore:       req.Offset+req.Limit < u.total,
+		NextCursor:    u.nextCursor,
+	}, nil
+}
+
+var linkPattern = regexp.MustCompile(`<([^>]*)>; rel="(\w+)"`)
+
+// pageLinks maps each rel of a Link header to the query of its url.
+func pageLinks(t *testing.T, header string) map[string]url.Values {
+	links := make(map[string]url.Values)
+	for _, match := range linkPattern.FindAllStringSubmatch(header, -1) {
+		target, err := url.Parse(match[1])
+		if err != nil {
+			t.Fatalf("link %q is invalid: %v", match[1], err)
+		}
+		links[match[2]] = target.Query()
+	}
+	return links
+}
+
+// inLogDir runs the test from a temp directory, the response logger writes
+// into ./assets/logs.
+func inLogDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "assets", "logs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestArticlesPageLinks(t *testing.T) {
+	inLogDir(t)
+
+	tests := []struct {
+		name       string
+		query      string
+		total      int
+		nextCursor string
+		// rel to the expected offset, "cursor:<c>" for a cursor link
+		want map[string]string
+	}{
+		{
+			name:  "first page",
+			query: "limit=10",
+			total: 35,
+			want:  map[string]string{"next": "10", "first": "0", "last": "30"},
+		},
+		{
+			name:  "middle page",
+			query: "limit=10&offset=10&tag=go",
+			total: 35,
+			want:  map[string]string{"next": "20", "prev": "0", "first": "0", "last": "30"},
+		},
+		{
+			name:  "last page",
+			query: "limit=10&offset=30",
+			total: 35,
+			want:  map[string]string{"prev": "20", "first": "0", "last": "30"},
+		},
+		{
+			name:  "prev never goes below zero",
+			query: "limit=10&offset=5",
+			total: 35,
+			want:  map[string]string{"next": "15", "prev": "0", "first": "0", "last": "30"},
+		},
+		{
+			name:  "empty list",
+			query: "limit=10",
+			total: 0,
+			want:  map[string]string{"first": "0", "last": "0"},
+		},
+		{
+			name:       "next follows the cursor",
+			query:      "limit=10&cursor=" + articles.EncodeCursor("2024-03-01T10:20:30", 7),
+			total:      35,
+			nextCursor: "next-page",
+			want:       map[string]string{"next": "cursor:next-page", "first": "0", "last": "30"},
+		},
+	}
+
+	for _, test := range tests {
+		usecase := &articlesUsecaseStub{total: test.total, nextCursor: test.nextCursor}
+		handler := articleshandlers.ArticlesHandler(nil, usecase)
+
+		app := fiber.New()
+		app.Get("/api/articles", func(c *fiber.Ctx) error {
+			c.Locals("userId", 0)
+			return c.Next()
+		}, handler.GetArticlesList)
+
+		res, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/api/articles?"+test.query, nil))
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", test.name, err)
+		}
+		if res.StatusCode != fiber.StatusOK {
+			t.Fatalf("%s: expect status 200, got %d", test.name, res.StatusCode)
+		}
+
+		links := pageLinks(t, res.Header.Get(fiber.HeaderLink))
+		if len(links) != len(test.want) {
+			t.Errorf("%s: expect rels %v, got %v", test.name, test.want, links)
+		}
+		for rel, want := range test.want {
+			link, ok := links[rel]
+			if !ok {
+				t.Errorf("%s: rel=%s is missing", test.name, rel)
+				continue
+			}
+			if link.Get("limit") != "10" {
+				t.Errorf("%s: rel=%s lost the limit: %v", test.name, rel, link)
+			}
+			if strings.Contains(test.query, "tag=go") && link.Get("tag") != "go" {
+				t.Errorf("%s: rel=%s lost the filters: %v", test.name, rel, link)
+			}
+			if cursor, ok := strings.CutPrefix(want, "cursor:"); ok {
+				if link.Get("cursor") != cursor || link.Has("offset") {
+					t.Errorf("%s: rel=%s expect cursor %s, got %v", test.name, rel, cursor, link)
+				}
+				continue
+			}
+			if link.Get("offset") != want || link.Has("cursor") {
+				t.Errorf("%s: rel=%s expect offset %s, got %v", test.name, rel, want, link)
+			}
+		}
+	}
+}
+
+var placeholderPattern = regexp.MustCompile(`\$(\d+)`)
+
+// checkPlaceholders makes sure the statement uses $1..$n for its n values,
+// no more and none skipped.
+func checkPlaceholders(t *testing.T, name, query string, values []any) {
+	seen := make(map[int]bool)
+	for _, match := range placeholderPattern.FindAllStringSubmatch(query, -1) {
+		n, _ := strconv.Atoi(match[1])
+		seen[n] = true
+	}
+	used := make([]int, 0, len(seen))
+	for n := range seen {
+		used = append(used, n)
+	}
+	sort.Ints(used)
+
+	if len(used) != len(values) {
+		t.Errorf("%s: %d values for placeholders %v", name, len(values), used)
+		return
+	}
+	for i, n := range used {
+		if n != i+1 {
+			t.Errorf("%s: %d values for placeholders %v", name, len(values), used)
+			return
+		}
+	}
+}
+
+func TestFindArticlePlaceholders(t *testing.T) {
+	after := &articles.Cursor{Time: "2024-03-01T10:20:30", Id: 7}
+
+	// Every combination of the filters, feed, drafts, search and cursor
+	for mask := 0; mask < 1<<7; mask++ {
+		req := &articles.ArticleFilter{Limit: 20, Offset: 40}
+		flags := make([]string, 0)
+		if mask&1 != 0 {
+			req.Tag = "go"
+			flags = append(flags, "tag")
+		}
+		if mask&2 != 0 {
+			req.Author = "jake"
+			flags = append(flags, "author")
+		}
+		if mask&4 != 0 {
+			req.Favorited = "jane"
+			flags = append(flags, "favorited")
+		}
+		if mask&8 != 0 {
+			req.IsFeed = true
+			flags = append(flags, "feed")
+		}
+		if mask&16 != 0 {
+			req.Drafts = true
+			flags = append(flags, "drafts")
+		}
+		if mask&32 != 0 {
+			req.Query = "dragons"
+			flags = append(flags, "search")
+		}
+		if mask&64 != 0 {
+			req.After = after
+			flags = append(flags, "cursor")
+		}
+		name := fmt.Sprintf("[%s]", strings.Join(flags, ","))
+
+		find := articlespatterns.FindArticleBuilder(nil, req)
+		query, values := articlespatterns.FindProductEngineer(find).FindArticle(1).Query()
+		checkPlaceholders(t, "find "+name, query, values)
+
+		countReq := *req
+		countReq.After = nil
+		count := articlespatterns.FindArticleBuilder(nil, &countReq)
+		query, values = articlespatterns.FindProductEngineer(count).CountArticle(1).Query()
+		checkPlaceholders(t, "count "+name, query, values)
+	}
+}
+
+func TestRestoreRevisionTitleUsed(t *testing.T) {
+	inLogDir(t)
+
+	usecase := &articlesUsecaseStub{restoreErr: fmt.Errorf("title has been used")}
+	handler := articleshandlers.ArticlesHandler(nil, usecase)
+
+	app := fiber.New()
+	app.Post("/api/articles/:slug/revisions/:n/restore", func(c *fiber.Ctx) error {
+		c.Locals("userId", 1)
+		return c.Next()
+	}, handler.RestoreRevision)
+
+	res, err := app.Test(httptest.NewRequest(fiber.MethodPost, "/api/articles/dragons/revisions/1/restore", nil))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if res.StatusCode != fiber.StatusConflict {
+		t.Errorf("expect status 409, got %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,65 @@
+package unittest
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NattpkJsw/real-world-api-go/config"
+	"github.com/NattpkJsw/real-world-api-go/modules/users"
+	usersHandlers "github.com/NattpkJsw/real-world-api-go/modules/users/usersHandlers"
+	usersUsecases "github.com/NattpkJsw/real-world-api-go/modules/users/usersUsecases"
+	"github.com/NattpkJsw/real-world-api-go/pkg/throttle"
+	"github.com/NattpkJsw/real-world-api-go/pkg/tokencache"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestChangePasswordWithApiKey(t *testing.T) {
+	inLogDir(t)
+
+	// The usecase is never reached, a call would panic on the nil interface
+	var usecase usersUsecases.IUsersUsecase
+	handler := usersHandlers.UsersHandler(nil, usecase)
+
+	app := fiber.New()
+	app.Post("/api/users/password", func(c *fiber.Ctx) error {
+		c.Locals("userId", 1)
+		c.Locals("accessToken", "")
+		c.Locals("apiKeyId", "key")
+		return c.Next()
+	}, handler.ChangePassword)
+
+	body := `{"user":{"currentPassword":"old-password","newPassword":"new-password"}}`
+	req := httptest.NewRequest(fiber.MethodPost, "/api/users/password", strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	res, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if res.StatusCode != fiber.StatusForbidden {
+		t.Errorf("expect status 403, got %d", res.StatusCode)
+	}
+}
+
+func TestChangePasswordNeedsSession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte(impersonationEnv), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.LoadConfig(path)
+
+	// Any repository call panics, the sessions must not be touched
+	repo := &usersRepositoryStub{}
+	usecase := usersUsecases.UsersUsecase(cfg, repo, nil, throttle.MemoryStore(), tokencache.NewCache(0, time.Minute), nil, nil, nil)
+
+	err := usecase.ChangePassword(1, "", &users.PasswordChange{
+		CurrentPassword: "old-password",
+		NewPassword:     "new-password",
+	})
+	if err == nil || err.Error() != "session is required" {
+		t.Errorf("expect session is required, got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package unittest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/NattpkJsw/real-world-api-go/pkg/passwords"
+)
+
+func writeBreachedList(t *testing.T, breached []string) string {
+	lines := make([]string, 0, len(breached))
+	for i, password := range breached {
+		sum := sha1.Sum([]byte(password))
+		lines = append(lines, fmt.Sprintf("%s:%d", strings.ToUpper(hex.EncodeToString(sum[:])), i+1))
+	}
+	sort.Strings(lines)
+
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0600); err != nil {
+		t.Fatalf("write breached list failed: %v", err)
+	}
+	return path
+}
+
+func TestBreachedList(t *testing.T) {
+	breached := []string{"password", "123456", "qwerty", "letmein", "dragon", "monkey", "iloveyou", "trustno1"}
+	list, err := passwords.OpenBreachedList(writeBreachedList(t, breached))
+	if err != nil {
+		t.Fatalf("expected: %v, got: %v", nil, err.Error())
+	}
+	defer list.Close()
+
+	for _, password := range breached {
+		if found, err := list.Contains(password); err != nil || !found {
+			t.Errorf("%s expected: %v, got: %v %v", password, true, found, err)
+		}
+	}
+	for _, password := range []string{"correct horse battery staple", "Password", ""} {
+		if found, err := list.Contains(password); err != nil || found {
+			t.Errorf("%s expected: %v, got: %v %v", password, false, found, err)
+		}
+	}
+}
+
+type testPasswordPolicy struct {
+	password string
+	expected []string
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	list, err := passwords.OpenBreachedList(writeBreachedList(t, []string{"Summer2024!"}))
+	if err != nil {
+		t.Fatalf("expected: %v, got: %v", nil, err.Error())
+	}
+	defer list.Close()
+
+	checker := passwords.NewChecker(&passwords.Policy{
+		MinLength: 8,
+		Require:   []passwords.Class{passwords.Upper, passwords.Digit},
+	}, list)
+
+	tests := []testPasswordPolicy{
+		{password: "", expected: []string{"can't be blank"}},
+		{password: "Ab1", expected: []string{"is too short (minimum is 8 characters)"}},
+		{password: "abcdefgh1", expected: []string{"must contain an uppercase letter"}},
+		{password: "Xjakejake9", expected: []string{"must not contain your username or email"}},
+		{password: "Summer2024!", expected: []string{"has appeared in a data breach, please choose another one"}},
+		{password: "Tr0ubadour-horse", expected: []string{}},
+	}
+
+	for _, test := range tests {
+		problems := checker.Check(test.password, "jake", "jake@j.com")
+		if strings.Join(problems, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("%s expected: %v, got: %v", test.password, test.expected, problems)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package unittest
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NattpkJsw/real-world-api-go/pkg/scheduler"
+)
+
+func TestSchedulerEvery(t *testing.T) {
+	var runs, failing atomic.Int32
+
+	jobs := scheduler.NewScheduler()
+	jobs.Every("count", 10*time.Millisecond, func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	})
+	// A failing or panicking job must keep being scheduled
+	jobs.Every("fail", 10*time.Millisecond, func(ctx context.Context) error {
+		if failing.Add(1)%2 == 0 {
+			panic("boom")
+		}
+		return fmt.Errorf("boom")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	jobs.Start(ctx)
+	time.Sleep(55 * time.Millisecond)
+	cancel()
+	jobs.Wait()
+
+	if runs.Load() < 3 {
+		t.Errorf("expected: %v, got: %v", "at least 3 runs", runs.Load())
+	}
+	if failing.Load() < 3 {
+		t.Errorf("expected: %v, got: %v", "at least 3 runs", failing.Load())
+	}
+
+	stopped := runs.Load()
+	time.Sleep(30 * time.Millisecond)
+	if runs.Load() != stopped {
+		t.Errorf("expected: %v, got: %v", stopped, runs.Load())
+	}
+}
@@ -0,0 +1,138 @@
+package unittest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NattpkJsw/real-world-api-go/pkg/throttle"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func throttlePolicy() *throttle.Policy {
+	return &throttle.Policy{
+		MaxAttempts:     5,
+		LockoutDuration: 15 * time.Minute,
+		BackoffAfter:    3,
+		BackoffBase:     time.Second,
+		Window:          time.Hour,
+	}
+}
+
+func TestThrottleBackoffAndLockout(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	limiter := throttle.NewThrottleWithClock(throttle.MemoryStore(), throttlePolicy(), clock.Now)
+
+	// The back-off starts at the third failure and doubles, the fifth locks
+	want := []time.Duration{0, 0, time.Second, 2 * time.Second, 15 * time.Minute}
+	for i, expected := range want {
+		wait, err := limiter.Fail("user@example.com")
+		if err != nil {
+			t.Fatalf("fail %d: %v", i+1, err)
+		}
+		if wait != expected {
+			t.Errorf("fail %d: expect wait %v, got %v", i+1, expected, wait)
+		}
+	}
+
+	if wait, _ := limiter.Check("user@example.com"); wait != 15*time.Minute {
+		t.Errorf("expect locked for 15m, got %v", wait)
+	}
+	clock.Advance(10 * time.Minute)
+	if wait, _ := limiter.Check("user@example.com"); wait != 5*time.Minute {
+		t.Errorf("expect 5m left on the lock, got %v", wait)
+	}
+	clock.Advance(5 * time.Minute)
+	if wait, _ := limiter.Check("user@example.com"); wait != 0 {
+		t.Errorf("expect the lock served, got %v", wait)
+	}
+
+	// The lock starts the count over
+	if wait, _ := limiter.Fail("user@example.com"); wait != 0 {
+		t.Errorf("expect no wait after the lock, got %v", wait)
+	}
+}
+
+func TestThrottleBackoffElapses(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	limiter := throttle.NewThrottleWithClock(throttle.MemoryStore(), throttlePolicy(), clock.Now)
+
+	for i := 0; i < 4; i++ {
+		limiter.Fail("key")
+	}
+	if wait, _ := limiter.Check("key"); wait != 2*time.Second {
+		t.Errorf("expect 2s back-off, got %v", wait)
+	}
+	clock.Advance(2 * time.Second)
+	if wait, _ := limiter.Check("key"); wait != 0 {
+		t.Errorf("expect the back-off served, got %v", wait)
+	}
+}
+
+func TestThrottleWindowAndReset(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	limiter := throttle.NewThrottleWithClock(throttle.MemoryStore(), throttlePolicy(), clock.Now)
+
+	for i := 0; i < 4; i++ {
+		limiter.Fail("window")
+	}
+	// Failures older than the window are forgotten
+	clock.Advance(time.Hour + time.Second)
+	if wait, _ := limiter.Check("window"); wait != 0 {
+		t.Errorf("expect no wait past the window, got %v", wait)
+	}
+	if wait, _ := limiter.Fail("window"); wait != 0 {
+		t.Errorf("expect the count to start over, got %v", wait)
+	}
+
+	for i := 0; i < 3; i++ {
+		limiter.Fail("reset")
+	}
+	if err := limiter.Reset("reset"); err != nil {
+		t.Fatalf("reset failed: %v", err)
+	}
+	if wait, _ := limiter.Check("reset"); wait != 0 {
+		t.Errorf("expect no wait after reset, got %v", wait)
+	}
+}
+
+func TestThrottleConcurrentFailures(t *testing.T) {
+	store := throttle.MemoryStore()
+	policy := throttlePolicy()
+	policy.MaxAttempts = 1000
+	limiter := throttle.NewThrottle(store, policy)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limiter.Fail("parallel")
+		}()
+	}
+	wg.Wait()
+
+	attempt, err := store.Get("parallel")
+	if err != nil || attempt == nil {
+		t.Fatalf("get attempt failed: %v", err)
+	}
+	if attempt.Failures != 50 {
+		t.Errorf("expect every failure counted, got %d", attempt.Failures)
+	}
+}
@@ -0,0 +1,57 @@
+package unittest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NattpkJsw/real-world-api-go/pkg/tokencache"
+)
+
+func TestTokenCache(t *testing.T) {
+	cache := tokencache.NewCache(2, time.Minute)
+
+	cache.Set("a", 1, cache.Version())
+	if _, ok := cache.Get("a"); ok {
+		t.Errorf("expected: %v, got: %v", "miss while disabled", "hit")
+	}
+
+	cache.SetEnabled(true)
+	cache.Set("a", 1, cache.Version())
+	cache.Set("b", 2, cache.Version())
+	if userId, ok := cache.Get("a"); !ok || userId != 1 {
+		t.Errorf("expected: %v, got: %v", 1, userId)
+	}
+
+	// "b" is the least recently used one
+	cache.Set("c", 1, cache.Version())
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("expected: %v, got: %v", "b evicted", "hit")
+	}
+
+	// a revocation between the lookup and Set must win
+	version := cache.Version()
+	cache.Delete("d")
+	cache.Set("d", 3, version)
+	if _, ok := cache.Get("d"); ok {
+		t.Errorf("expected: %v, got: %v", "stale set ignored", "hit")
+	}
+
+	cache.DeleteUser(1)
+	if _, ok := cache.Get("a"); ok {
+		t.Errorf("expected: %v, got: %v", "a deleted", "hit")
+	}
+	if _, ok := cache.Get("c"); ok {
+		t.Errorf("expected: %v, got: %v", "c deleted", "hit")
+	}
+
+	// the denylist is kept while the cache is disabled
+	cache.SetEnabled(false)
+	cache.Revoke("jti-1", time.Now().Add(time.Minute))
+	cache.Revoke("jti-2", time.Now().Add(-time.Minute))
+	if !cache.IsRevoked("jti-1") {
+		t.Errorf("expected: %v, got: %v", true, false)
+	}
+	if cache.IsRevoked("jti-2") {
+		t.Errorf("expected: %v, got: %v", false, true)
+	}
+}
@@ -0,0 +1,67 @@
+package unittest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NattpkJsw/real-world-api-go/pkg/totp"
+)
+
+// base32 of the RFC 6238 SHA1 seed "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+type testTotpCode struct {
+	unix     int64
+	expected string
+}
+
+func TestTotpCode(t *testing.T) {
+	// RFC 6238 appendix B, truncated to six digits
+	tests := []testTotpCode{
+		{unix: 59, expected: "287082"},
+		{unix: 1111111109, expected: "081804"},
+		{unix: 1111111111, expected: "050471"},
+		{unix: 1234567890, expected: "005924"},
+		{unix: 2000000000, expected: "279037"},
+		{unix: 20000000000, expected: "353130"},
+	}
+
+	for _, test := range tests {
+		code, err := totp.Code(rfcSecret, totp.Step(time.Unix(test.unix, 0)))
+		if err != nil {
+			t.Errorf("expected: %v, got: %v", nil, err.Error())
+		}
+		if code != test.expected {
+			t.Errorf("expected: %v, got: %v", test.expected, code)
+		}
+	}
+}
+
+type testTotpValidate struct {
+	code     string
+	now      int64
+	lastStep int64
+	isValid  bool
+}
+
+func TestTotpValidate(t *testing.T) {
+	// "050471" belongs to step 37037037
+	tests := []testTotpValidate{
+		{code: "050471", now: 1111111111, lastStep: 0, isValid: true},
+		{code: "050471", now: 1111111111 + totp.Period, lastStep: 0, isValid: true},
+		{code: "050471", now: 1111111111 + 2*totp.Period, lastStep: 0, isValid: false},
+		{code: "050471", now: 1111111111, lastStep: 37037037, isValid: false},
+		{code: "000000", now: 1111111111, lastStep: 0, isValid: false},
+		{code: "50471", now: 1111111111, lastStep: 0, isValid: false},
+	}
+
+	for _, test := range tests {
+		step, ok := totp.Validate(rfcSecret, test.code, time.Unix(test.now, 0), test.lastStep)
+		if ok != test.isValid {
+			t.Errorf("expected: %v, got: %v", test.isValid, ok)
+		}
+		if ok && step != 37037037 {
+			t.Errorf("expected: %v, got: %v", 37037037, step)
+		}
+	}
+}